@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/parser"
+	"go/token"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// listedPackage is the part of go list -json's output the dependency graph needs.
+type listedPackage struct {
+	Dir          string
+	ImportPath   string
+	Imports      []string
+	TestImports  []string
+	XTestImports []string
+}
+
+// imports reports whether the package or its tests import path.
+func (p *listedPackage) imports(path string) bool {
+	for _, list := range [][]string{p.Imports, p.TestImports, p.XTestImports} {
+		for _, imp := range list {
+			if imp == path {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// depGraph is which packages of a module import which, for -affected.
+type depGraph struct {
+	// packages by directory.
+	packages map[string]*listedPackage
+	// importers are the directories of the packages importing each import path, directly or from their tests.
+	importers map[string][]string
+}
+
+// loadDepGraph lists the packages below root with go list.
+func loadDepGraph(root string) (*depGraph, error) {
+	cmd := exec.Command(goCommand, "list", "-e", "-json", "./...")
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	g := &depGraph{packages: map[string]*listedPackage{}, importers: map[string][]string{}}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		p := &listedPackage{}
+		err := dec.Decode(p)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		g.packages[p.Dir] = p
+		seen := map[string]bool{}
+		for _, list := range [][]string{p.Imports, p.TestImports, p.XTestImports} {
+			for _, imp := range list {
+				if !seen[imp] {
+					seen[imp] = true
+					g.importers[imp] = append(g.importers[imp], p.Dir)
+				}
+			}
+		}
+	}
+	return g, nil
+}
+
+// knows reports whether the graph is still right about the .go file, i.e. its
+// package was listed and it doesn't import anything the package didn't.
+func (g *depGraph) knows(file string) bool {
+	p := g.packages[filepath.Dir(file)]
+	if p == nil {
+		return false
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.ImportsOnly)
+	if err != nil {
+		return false
+	}
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil || !p.imports(path) {
+			return false
+		}
+	}
+	return true
+}
+
+// affected returns the directories of the packages in dir and every package that depends on them.
+func (g *depGraph) affected(dir string) []string {
+	seen := map[string]bool{dir: true}
+	queue := []string{dir}
+	for len(queue) > 0 {
+		p := g.packages[queue[0]]
+		queue = queue[1:]
+		if p == nil {
+			continue
+		}
+		for _, importer := range g.importers[p.ImportPath] {
+			if !seen[importer] {
+				seen[importer] = true
+				queue = append(queue, importer)
+			}
+		}
+	}
+
+	var dirs []string
+	for dir := range seen {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// knowsDeps reports whether the dependency graph is listed and still right about
+// the changed .go file, test files included as what they import counts too.
+func (m *Module) knowsDeps(file string) bool {
+	return m.deps != nil && m.deps.knows(file)
+}
+
+// affectedPackages returns the package of the changed .go file and those depending
+// on it, as ./ paths relative to the module's root, or just the file's own
+// package without a graph.
+func (m *Module) affectedPackages(file string) []string {
+	own := packagesOf(m.Root, map[string]bool{file: true})
+	if strings.HasSuffix(file, "_test.go") || m.deps == nil {
+		// Nothing imports tests.
+		return own
+	}
+
+	root, err := filepath.Abs(m.Root)
+	if err != nil {
+		return own
+	}
+	var pkgs []string
+	for _, dir := range m.deps.affected(filepath.Dir(file)) {
+		if pkg, ok := relativePackage(root, dir); ok {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	return pkgs
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAffectedPackages(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "go.mod"), "module example.com/m\n")
+	writeFile(t, filepath.Join(dir, "a/a.go"), "package a\n")
+	writeFile(t, filepath.Join(dir, "b/b.go"), "package b\n\nimport _ \"example.com/m/a\"\n")
+	writeFile(t, filepath.Join(dir, "c/c_test.go"), "package c\n")
+	m := &Module{Dir: dir, Root: dir}
+
+	a := filepath.Join(dir, "a/a.go")
+	if got := strings.Join(m.affectedPackages(a), " "); got != "./a" {
+		t.Errorf("affectedPackages without a graph = %q, want just ./a", got)
+	}
+	var err error
+	m.deps, err = loadDepGraph(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.knowsDeps(a) {
+		t.Errorf("knowsDeps(a.go) = false for the graph just listed")
+	}
+	if got := strings.Join(m.affectedPackages(a), " "); got != "./a ./b" {
+		t.Errorf("affectedPackages(a.go) = %q, want ./a ./b", got)
+	}
+
+	// A test importing a is only known once the graph is listed again.
+	c := filepath.Join(dir, "c/c_test.go")
+	writeFile(t, c, "package c\n\nimport _ \"example.com/m/a\"\n")
+	if m.knowsDeps(c) {
+		t.Errorf("knowsDeps(c_test.go) = true after it imported another package")
+	}
+	m.deps, err = loadDepGraph(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(m.affectedPackages(a), " "); got != "./a ./b ./c" {
+		t.Errorf("affectedPackages(a.go) listed again = %q, want ./a ./b ./c", got)
+	}
+}
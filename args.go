@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// goFlags inserts flags straight after "go sub" in args, commands that aren't "go sub" are left alone.
+func goFlags(args []string, sub string, flags ...string) []string {
+	if len(args) < 2 || args[0] != "go" || args[1] != sub {
+		return args
+	}
+	out := []string{args[0], args[1]}
+	out = append(out, flags...)
+	return append(out, args[2:]...)
+}
+
+// valueFlags are the flags of go build and go test that take a value, which
+// may be given as the arg after them rather than with =.
+var valueFlags = map[string]bool{
+	"C": true, "asmflags": true, "buildmode": true, "compiler": true, "gccgoflags": true,
+	"gcflags": true, "installsuffix": true, "ldflags": true, "mod": true, "modfile": true,
+	"o": true, "overlay": true, "p": true, "pgo": true, "pkgdir": true, "tags": true,
+	"toolexec": true, "covermode": true, "coverpkg": true, "exec": true,
+
+	"bench": true, "benchtime": true, "blockprofile": true, "blockprofilerate": true,
+	"count": true, "coverprofile": true, "cpu": true, "cpuprofile": true, "fuzz": true,
+	"fuzzminimizetime": true, "fuzztime": true, "list": true, "memprofile": true,
+	"memprofilerate": true, "mutexprofile": true, "mutexprofilefraction": true,
+	"outputdir": true, "parallel": true, "run": true, "shuffle": true, "skip": true,
+	"timeout": true, "trace": true, "vet": true,
+}
+
+// takesValue reports whether the go flag arg has its value in the next arg,
+// given as -flag value rather than -flag=value.
+func takesValue(arg string) bool {
+	name := strings.TrimLeft(arg, "-")
+	return !strings.Contains(name, "=") && valueFlags[strings.TrimPrefix(name, "test.")]
+}
+
+// passedArgs returns the args given after "--" on the command line args, for the
+// test command. rest are the args left over once the flags were parsed.
+func passedArgs(args, rest []string) []string {
+	if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+		return rest
+	}
+	return nil
+}
+
+// commandWithArgs splits command into Args like commandArgs with args after it,
+// as they are unless it's run in a shell, so go flags can still be put in after
+// "go test" whatever args holds.
+func commandWithArgs(command string, useShell bool, args []string) []string {
+	if needsShell(command, useShell) {
+		return commandArgs(withArgs(command, args), useShell)
+	}
+	return append(commandArgs(command, useShell), args...)
+}
+
+// withFile puts file in place of every {} in command, quoted for the shell.
+func withFile(command, file string) string {
+	return strings.ReplaceAll(command, "{}", shellQuote(file))
+}
+
+// mainFlag is a flag.Value for -main-only, the main package to build, given
+// as just -main-only for the one in the module's directory.
+type mainFlag string
+
+func (mf *mainFlag) String() string {
+	return string(*mf)
+}
+
+// Set takes a bool or the path of the main package.
+func (mf *mainFlag) Set(value string) error {
+	on, err := strconv.ParseBool(value)
+	switch {
+	case err != nil:
+		*mf = mainFlag(value)
+	case on:
+		*mf = "."
+	default:
+		*mf = ""
+	}
+	return nil
+}
+
+// IsBoolFlag lets it be given as just -main-only.
+func (mf *mainFlag) IsBoolFlag() bool {
+	return true
+}
+
+// withArgs appends args to command, quoted for the shell.
+func withArgs(command string, args []string) string {
+	for _, arg := range args {
+		command += " " + shellQuote(arg)
+	}
+	return command
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// autoFlag is a flag.Value like a bool flag that defaults to "auto", for
+// settings decided from the terminal unless they're turned on or off.
+type autoFlag string
+
+func (af *autoFlag) String() string {
+	if *af == "" {
+		return "auto"
+	}
+	return string(*af)
+}
+
+// Set takes a bool or "auto".
+func (af *autoFlag) Set(value string) error {
+	if value == "auto" {
+		*af = "auto"
+		return nil
+	}
+	on, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("must be true, false or auto")
+	}
+	*af = autoFlag(strconv.FormatBool(on))
+	return nil
+}
+
+// IsBoolFlag lets it be given as just -ci.
+func (af *autoFlag) IsBoolFlag() bool {
+	return true
+}
+
+// ciMode reports whether the results are streamed as plain lines for -ci, by
+// default whenever out isn't a terminal.
+func ciMode(mode string, out io.Writer) bool {
+	switch mode {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return !isTerminal(out)
+}
+
+// plainConfig turns off the colors and links for -ci.
+func plainConfig(cfg Config) Config {
+	cfg.Color = "never"
+	cfg.Links = "never"
+	return cfg
+}
+
+// printCI writes cr as a line like "TEST: FAIL (3.4s)" followed by its output
+// if it failed, prefixed with its directory when there's more than one module.
+func printCI(out io.Writer, cr CommandResult, withDir bool) {
+	status := "OK"
+	switch cr.Status {
+	case StatusBad:
+		status = "FAIL"
+	case StatusWarn:
+		status = "WARN"
+	case StatusKilled:
+		status = "KILLED"
+	}
+	line := fmt.Sprintf("%s: %s (%.1fs)", strings.ToUpper(cr.Label()), status, cr.Duration.Seconds())
+	if withDir {
+		line = cr.Dir + " " + line
+	}
+	fmt.Fprintln(out, line)
+	if output := strings.TrimRight(cr.Output, "\n"); cr.Status != StatusOk && output != "" {
+		fmt.Fprintln(out, output)
+	}
+}
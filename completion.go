@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// isBoolFlag reports whether f is a flag that doesn't take a value.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// printCompletion writes a script completing the flags of fs for shell (bash, zsh or fish) to out.
+func printCompletion(shell string, fs *flag.FlagSet, out io.Writer) error {
+	var flags []*flag.Flag
+	fs.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, f)
+	})
+
+	switch shell {
+	case "bash":
+		var names []string
+		for _, f := range flags {
+			names = append(names, "-"+f.Name)
+		}
+		fmt.Fprintf(out, "_gowatch() {\n")
+		fmt.Fprintf(out, "\tCOMPREPLY=($(compgen -W %q -- \"${COMP_WORDS[COMP_CWORD]}\"))\n", strings.Join(names, " "))
+		fmt.Fprintf(out, "}\n")
+		fmt.Fprintf(out, "complete -o default -F _gowatch gowatch\n")
+	case "zsh":
+		fmt.Fprintf(out, "#compdef gowatch\n\n_arguments \\\n")
+		for _, f := range flags {
+			name, usage := flag.UnquoteUsage(f)
+			usage = strings.NewReplacer("'", "'\\''", "[", "\\[", "]", "\\]", ":", "\\:").Replace(usage)
+			if isBoolFlag(f) {
+				fmt.Fprintf(out, "\t'-%s[%s]' \\\n", f.Name, usage)
+			} else {
+				fmt.Fprintf(out, "\t'-%s[%s]:%s:_files' \\\n", f.Name, usage, name)
+			}
+		}
+		fmt.Fprintf(out, "\t'*:file:_files'\n")
+	case "fish":
+		for _, f := range flags {
+			_, usage := flag.UnquoteUsage(f)
+			usage = strings.ReplaceAll(usage, "'", "\\'")
+			if isBoolFlag(f) {
+				fmt.Fprintf(out, "complete -c gowatch -o %s -d '%s'\n", f.Name, usage)
+			} else {
+				fmt.Fprintf(out, "complete -c gowatch -o %s -r -d '%s'\n", f.Name, usage)
+			}
+		}
+	default:
+		return fmt.Errorf("can't complete for %q, only bash, zsh or fish", shell)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigFile is where options are read from by default.
+const ConfigFile = ".gowatch.yml"
+
+// fileConfig is the layout of the config file. Any key other than the
+// sections below is the name of a flag, set as if given on the command line.
+type fileConfig struct {
+	Colors  map[string]string `yaml:"colors"`
+	Rules   []Rule            `yaml:"rules"`
+	Running map[string]string `yaml:"running"`
+	Order   []string          `yaml:"order"`
+	Themes  map[string]Theme  `yaml:"themes"`
+	Labels  map[string]string `yaml:"labels"`
+	Tags    map[string]string `yaml:"tags"`
+	Groups  []WatchGroup      `yaml:"groups"`
+
+	Commands map[string]CommandPolicy `yaml:"commands"`
+
+	Presets map[string]map[string]interface{} `yaml:"presets"`
+
+	Flags map[string]interface{} `yaml:",inline"`
+}
+
+// readConfigFile reads the config file at path into cfg, a missing file is
+// fine unless it was asked for with -config. Flags already set on the command
+// line in fs keep their values.
+func readConfigFile(path string, cfg *Config, fs *flag.FlagSet) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	cfg.commandLine = set
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !set["config"] {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var fc fileConfig
+	err = yaml.Unmarshal(data, &fc)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	for name, value := range fc.Flags {
+		if fs.Lookup(name) == nil || name == "config" || name == "completion" {
+			return fmt.Errorf("%s: unknown option %q", path, name)
+		}
+		if set[name] {
+			continue
+		}
+		err = setFlag(fs, name, value)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+	}
+
+	if cfg.Colors == nil {
+		cfg.Colors = map[string]string{}
+	}
+	for part, spec := range fc.Colors {
+		cfg.Colors[part] = spec
+	}
+	cfg.Rules = append(cfg.Rules, fc.Rules...)
+	cfg.Groups = append(cfg.Groups, fc.Groups...)
+	if cfg.Running == nil {
+		cfg.Running = map[string]string{}
+	}
+	for name, msg := range fc.Running {
+		cfg.Running[name] = msg
+	}
+	if cfg.Themes == nil {
+		cfg.Themes = map[string]Theme{}
+	}
+	for name, theme := range fc.Themes {
+		cfg.Themes[name] = theme
+	}
+	if cfg.Labels == nil {
+		cfg.Labels = map[string]string{}
+	}
+	for name, label := range fc.Labels {
+		cfg.Labels[name] = label
+	}
+	if cfg.Tags == nil {
+		cfg.Tags = map[string]string{}
+	}
+	for name, tag := range fc.Tags {
+		cfg.Tags[name] = tag
+	}
+	if cfg.Commands == nil {
+		cfg.Commands = map[string]CommandPolicy{}
+	}
+	for name, policy := range fc.Commands {
+		if policy.Timeout < 0 || policy.Retries < 0 {
+			return fmt.Errorf("%s: commands: %s: the timeout and retries can't be negative", path, name)
+		}
+		for i, need := range policy.Needs {
+			policy.Needs[i] = strings.ToLower(need)
+		}
+		cfg.Commands[strings.ToLower(name)] = policy
+	}
+	for _, part := range fc.Order {
+		if !isDisplayPart(part) {
+			return fmt.Errorf("%s: order: unknown result %q, must be one of %s", path, part, strings.Join(displayParts, ", "))
+		}
+	}
+	cfg.Order = fc.Order
+	cfg.Presets = fc.Presets
+	return nil
+}
+
+// loadConfig reads the options from the command line args and the config file,
+// with the flags defined on fs. It's how they're read on startup and again on a reload.
+func loadConfig(fs *flag.FlagSet, args []string) (Config, error) {
+	cfg := Config{args: args}
+	defineFlags(fs, &cfg)
+	err := fs.Parse(args)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.TestArgs = passedArgs(args, fs.Args())
+	if fs.Arg(0) == "healthcheck" {
+		// Allow the flags after the command too, as in "gowatch healthcheck -health-file f".
+		cfg.healthcheck = true
+		err = fs.Parse(fs.Args()[1:])
+		if err != nil {
+			return cfg, err
+		}
+	}
+	if cfg.Completion != "" {
+		return cfg, nil
+	}
+	err = readConfigFile(cfg.ConfigFile, &cfg, fs)
+	if err != nil {
+		return cfg, err
+	}
+	if !cfg.NoIgnore {
+		cfg.Ignore = append(cfg.Ignore, DefaultIgnore...)
+	}
+	return cfg, nil
+}
+
+// setFlag sets the flag name to a value from the config file, a list sets a
+// repeatable flag once for each item.
+func setFlag(fs *flag.FlagSet, name string, value interface{}) error {
+	items, isList := value.([]interface{})
+	if !isList {
+		items = []interface{}{value}
+	}
+	for _, item := range items {
+		err := fs.Set(name, fmt.Sprint(item))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyPreset returns cfg with the options of the preset called name set on
+// top, apart from those given on the command line. An empty name leaves cfg as it is.
+func applyPreset(cfg Config, name string) (Config, error) {
+	if name == "" {
+		return cfg, nil
+	}
+	preset, found := cfg.Presets[name]
+	if !found {
+		return cfg, fmt.Errorf("unknown preset %q", name)
+	}
+
+	c := cfg
+	fs := flag.NewFlagSet("preset", flag.ContinueOnError)
+	defineFlags(fs, &c)
+	// Defining the flags reset everything to the defaults, and the
+	// repeatable ones mustn't append to cfg's lists.
+	c = cfg
+	for _, list := range []*[]string{&c.Modules, &c.Embeds, &c.Ignore, &c.Watch, &c.Targets, &c.TestOnly, &c.Include, &c.SkipTests} {
+		*list = append([]string(nil), *list...)
+	}
+
+	for key, value := range preset {
+		if fs.Lookup(key) == nil || key == "config" || key == "preset" || key == "completion" {
+			return cfg, fmt.Errorf("preset %s: unknown option %q", name, key)
+		}
+		if cfg.commandLine[key] {
+			continue
+		}
+		err := setFlag(fs, key, value)
+		if err != nil {
+			return cfg, fmt.Errorf("preset %s: %s: %v", name, key, err)
+		}
+	}
+	c.Preset = name
+	return c, nil
+}
+
+// presetNames returns the names of the presets, sorted.
+func presetNames(cfg Config) []string {
+	var names []string
+	for name := range cfg.Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// SaveCoverage keeps the coverage profile of the tests that have just passed in CoverProfile.
+func (builder *Builder) SaveCoverage() error {
+	if builder.coverTemp == "" {
+		return nil
+	}
+	data, err := os.ReadFile(builder.coverTemp)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		// The test command isn't go test, or it didn't get as far as writing one.
+		return nil
+	}
+	return writeFileAtomic(builder.CoverProfile, data)
+}
+
+// openCoverage shows the -coverprofile at path in the browser with go tool cover, in the background.
+func openCoverage(path string) error {
+	if path == "" {
+		return fmt.Errorf("there's no -coverprofile to open")
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("no coverage profile yet, the tests haven't passed")
+	}
+	cmd := exec.Command(goCommand, "tool", "cover", "-html="+path)
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
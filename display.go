@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Display draws the dashboard.
+type Display struct {
+	out io.Writer
+
+	// NoClear appends each redraw to the earlier ones, with Separator between them.
+	NoClear   bool
+	Separator string
+
+	// KeepScreen skips clearing the screen for the first redraw.
+	KeepScreen bool
+
+	// Badge shows just the overall status instead of every result, apart from those that failed.
+	Badge bool
+
+	// ShowCommand prints the command line of failed commands.
+	ShowCommand bool
+
+	// InPlace overwrites just the lines of the last redraw instead of clearing the screen.
+	InPlace bool
+
+	// Truncate cuts lines down to the width of the terminal instead of letting them wrap.
+	Truncate bool
+
+	// SameFailures collapses the test output when the same tests failed as the time before.
+	SameFailures bool
+
+	// CollapsePassing shows just the "ok" line of the packages whose tests passed.
+	CollapsePassing bool
+
+	// LastGood shows the output of the last passing run, dimmed, under a failure.
+	LastGood bool
+
+	// Columns shows the build and test results side by side when the terminal is wide enough.
+	Columns bool
+
+	// Rollup shows the status of every module combined on a line above them, and only the name of those that pass.
+	Rollup bool
+
+	// ProblemsOnly hides the results that aren't failures or warnings.
+	ProblemsOnly bool
+	// hidden counts the results ProblemsOnly left out of the redraw.
+	hidden int
+
+	drawn bool
+
+	// lastGood is the output of the last passing run of each command, by directory and name.
+	lastGood map[string]string
+
+	// lines are the lines the last in place redraw printed.
+	lines []string
+
+	// order is displayParts in the order they're shown.
+	order []string
+}
+
+// NewDisplay makes a display writing to out.
+func NewDisplay(cfg Config, out io.Writer) *Display {
+	d := &Display{
+		out:        out,
+		KeepScreen: cfg.KeepScreen,
+		lastGood:   map[string]string{},
+	}
+	d.Configure(cfg)
+	return d
+}
+
+// Configure sets the options of the display from cfg, e.g. after the config file was reloaded.
+func (d *Display) Configure(cfg Config) {
+	d.NoClear = cfg.NoClear
+	d.Separator = cfg.Separator
+	d.InPlace = cfg.InPlace
+	d.ShowCommand = cfg.ShowCommand
+	d.Badge = cfg.Badge
+	d.LastGood = cfg.LastGood
+	d.SameFailures = cfg.SameFails
+	d.CollapsePassing = cfg.CollapsePass
+	d.Truncate = cfg.Truncate
+	d.Columns = cfg.Columns
+	d.Rollup = cfg.Rollup
+	d.ProblemsOnly = cfg.ProblemsOnly
+	d.order = displayOrder(cfg.Order)
+}
+
+// Show redraws the results of every module between banner and footer.
+func (d *Display) Show(modules []*Module, banner, footer string) {
+	var buf bytes.Buffer
+	d.draw(&buf, modules, banner, footer)
+	text := buf.String()
+	if d.Truncate {
+		text = truncateLines(text, terminalColumns())
+	}
+
+	if d.InPlace && !d.NoClear {
+		lines := strings.SplitAfter(text, "\n")
+		if lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+		io.WriteString(d.out, redrawLines(d.lines, lines))
+		d.lines = lines
+		return
+	}
+
+	if !d.NoClear {
+		if d.drawn || !d.KeepScreen {
+			clear(d.out)
+		}
+	} else if d.drawn && d.Separator != "" {
+		fmt.Fprintln(d.out, dim(strings.ReplaceAll(d.Separator, "{time}", time.Now().Format("15:04:05"))))
+	}
+	d.drawn = true
+	io.WriteString(d.out, text)
+}
+
+// Forget stops the next in place redraw from overwriting lines, e.g. after the screen was cleared.
+func (d *Display) Forget() {
+	d.lines = nil
+}
+
+// redrawLines returns what turns the lines last printed, above the cursor, into
+// lines, rewriting only those that changed so there's as little to flicker as possible.
+func redrawLines(last, lines []string) string {
+	var b strings.Builder
+	if len(last) > 0 {
+		fmt.Fprintf(&b, "\033[%dA", len(last))
+	}
+	for i, line := range lines {
+		switch {
+		case i < len(last) && last[i] == line:
+			// Move down over it.
+			b.WriteString("\033[1B")
+		case i < len(last):
+			b.WriteString("\033[2K" + line)
+		default:
+			b.WriteString(line)
+		}
+	}
+	if len(lines) < len(last) {
+		// Clear what's left of the longer redraw before.
+		b.WriteString("\033[J")
+	}
+	return b.String()
+}
+
+// draw prints the results to out.
+func (d *Display) draw(out io.Writer, modules []*Module, banner, footer string) {
+	if banner != "" {
+		fmt.Fprintln(out, refresh(banner))
+	}
+
+	d.hidden = 0
+	if d.Badge {
+		d.badge(out, modules)
+	} else {
+		d.results(out, modules)
+	}
+	if d.hidden > 0 {
+		fmt.Fprintln(out, dim(fmt.Sprintf("showing only failures and warnings, %d more hidden (f shows them)", d.hidden)))
+	}
+	if footer != "" {
+		fmt.Fprintln(out, dim(footer))
+	}
+}
+
+// badgeText is the badge shown for each overall status.
+var badgeText = map[Status]string{
+	StatusDirty: "⟳ RUNNING",
+	StatusOk:    "✔ PASS",
+	StatusBad:   "✘ FAIL",
+}
+
+// badge prints the overall status centred on a line, followed by whatever failed.
+func (d *Display) badge(out io.Writer, modules []*Module) {
+	status := overallStatus(modules)
+	state := ok
+	switch status {
+	case StatusBad:
+		state = bad
+	case StatusDirty:
+		state = refresh
+	}
+	text := badgeText[status]
+	pad := (terminalColumns() - displayWidth(text)) / 2
+	if pad < 0 {
+		pad = 0
+	}
+	fmt.Fprintln(out, strings.Repeat(" ", pad)+state(text))
+
+	for _, m := range modules {
+		failed := []*CommandResult{&m.Build, &m.Test, &m.Examples, &m.Rerun}
+		for i := range m.Targets {
+			failed = append(failed, &m.Targets[i])
+		}
+		for _, cr := range failed {
+			if cr.Status == StatusBad && cr.Name != "" {
+				d.result(out, cr)
+			}
+		}
+	}
+}
+
+// results prints the results of every module.
+func (d *Display) results(out io.Writer, modules []*Module) {
+	rollup := d.Rollup && len(modules) > 1
+	if rollup {
+		fmt.Fprintln(out, rollupLine(modules))
+	}
+	for _, m := range modules {
+		if rollup && m.status() == StatusOk {
+			fmt.Fprintln(out, normal("["+m.Dir+"]")+" "+ok(StatusIcon[StatusOk]))
+			continue
+		}
+		if len(modules) > 1 {
+			fmt.Fprintln(out, normal("["+m.Dir+"]"))
+		}
+		paired := false
+		for _, part := range d.order {
+			if d.Columns && (part == "build" || part == "test") && terminalColumns() >= minColumnsWidth {
+				if !paired {
+					d.pair(out, m, part)
+				}
+				paired = true
+				continue
+			}
+			d.part(out, m, part)
+		}
+		if m.Killed.Name != "" {
+			d.result(out, &m.Killed)
+		}
+	}
+}
+
+// rollupLine combines the status of every module into one line, naming those
+// that fail or are still running.
+func rollupLine(modules []*Module) string {
+	var failing, running []string
+	for _, m := range modules {
+		switch m.status() {
+		case StatusBad:
+			failing = append(failing, m.Dir)
+		case StatusDirty:
+			running = append(running, m.Dir)
+		}
+	}
+	switch {
+	case len(failing) > 0:
+		return bad(fmt.Sprintf("✘ %d of %d modules failing: %s", len(failing), len(modules), listFiles(failing, 5)))
+	case len(running) > 0:
+		return refresh(fmt.Sprintf("⟳ %d of %d modules running: %s", len(running), len(modules), listFiles(running, 5)))
+	}
+	return ok(fmt.Sprintf("✔ all %d modules pass", len(modules)))
+}
+
+// listFiles lists files, only the first max of them and how many more there are if there are too many.
+func listFiles(files []string, max int) string {
+	if len(files) <= max {
+		return strings.Join(files, ", ")
+	}
+	return strings.Join(files[:max], ", ") + fmt.Sprintf(" and %d more", len(files)-max)
+}
+
+// minColumnsWidth is how wide the terminal has to be for -columns, narrower they're stacked as usual.
+const minColumnsWidth = 100
+
+// pair prints the build and test results of m side by side, first on the left.
+func (d *Display) pair(out io.Writer, m *Module, first string) {
+	second := "test"
+	if first == "test" {
+		second = "build"
+	}
+	var left, right bytes.Buffer
+	d.part(&left, m, first)
+	d.part(&right, m, second)
+	if left.Len() == 0 || right.Len() == 0 {
+		// Nothing to put beside it, e.g. without tests.
+		io.WriteString(out, left.String()+right.String())
+		return
+	}
+	io.WriteString(out, sideBySide(left.String(), right.String(), terminalColumns()))
+}
+
+// part prints the results of m making up part, one of displayParts.
+func (d *Display) part(out io.Writer, m *Module, part string) {
+	switch part {
+	case "build":
+		if m.Build.Status == StatusBad {
+			for _, hint := range m.Build.Hints {
+				fmt.Fprintln(out, bad(hint))
+			}
+		}
+		d.result(out, &m.Build)
+	case "targets":
+		for i := range m.Targets {
+			d.result(out, &m.Targets[i])
+		}
+	case "tidy":
+		if m.Tidy.Name != "" {
+			d.result(out, &m.Tidy)
+		}
+	case "test":
+		if !m.Builder.HasTests() {
+			break
+		}
+		if races := m.Test.Tests.Races; races > 0 && m.Test.Status != StatusDirty {
+			fmt.Fprintln(out, warn("⚠ DATA RACE: the race detector found "+plural(races, "race")))
+		}
+		if summary := m.Test.Tests.Summary(); m.Test.Status == StatusBad && summary != "" {
+			fmt.Fprintln(out, bad(summary))
+		}
+		cr := m.Test
+		if d.SameFailures && m.sameFailures && m.Test.Status == StatusBad {
+			cr.Output = "same " + plural(len(m.Test.Tests.Failures), "failure") + " as before"
+			cr.Errors = nil
+		} else if m.Builder.PerPackage && len(m.Packages) > 0 {
+			untested := 0
+			for i := range m.Packages {
+				if isUntested(m.Packages[i]) {
+					untested++
+					continue
+				}
+				pkg := m.Packages[i]
+				if d.CollapsePassing {
+					pkg.Output = collapsePassing(pkg.Output)
+				}
+				d.result(out, &pkg)
+			}
+			// The packages already show their output.
+			cr.Output = ""
+			if untested > 0 {
+				cr.Output = plural(untested, "package") + " without test files"
+			}
+			cr.Errors = nil
+		} else {
+			cr.Output = withoutUntested(cr.Output)
+			if d.CollapsePassing {
+				cr.Output = collapsePassing(cr.Output)
+			}
+		}
+		d.result(out, &cr)
+	case "examples":
+		if m.Builder.HasExamples() {
+			d.result(out, &m.Examples)
+		}
+	case "rerun":
+		if m.Rerun.Name != "" {
+			d.result(out, &m.Rerun)
+		}
+	case "run":
+		if m.Run.Name != "" {
+			d.result(out, &m.Run)
+		}
+	case "rules":
+		for i := range m.Rules {
+			if m.Rules[i].Name != "" {
+				d.result(out, &m.Rules[i])
+			}
+		}
+	case "tail":
+		tail := m.Builder.runCmd.Tail
+		if tail == nil {
+			break
+		}
+		if lines := tail.Lines(); len(lines) > 0 {
+			run := CommandResult{Name: m.Builder.runCmd.Name}
+			fmt.Fprintln(out, dim(fmt.Sprintf("── the last %d lines of %s ──", tail.max, run.Label())))
+			for _, line := range lines {
+				fmt.Fprintln(out, "  "+line)
+			}
+		}
+	}
+}
+
+// displayParts are the parts of a module's results, in the order they're shown by default.
+var displayParts = []string{"build", "targets", "tidy", "test", "examples", "rerun", "run", "rules", "tail"}
+
+// isDisplayPart reports whether part is one of displayParts.
+func isDisplayPart(part string) bool {
+	for _, p := range displayParts {
+		if p == part {
+			return true
+		}
+	}
+	return false
+}
+
+// displayOrder returns every one of displayParts, those in order first.
+func displayOrder(order []string) []string {
+	var parts []string
+	seen := map[string]bool{}
+	for _, part := range append(append([]string(nil), order...), displayParts...) {
+		if !seen[part] {
+			seen[part] = true
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// result prints cr, with the command line that failed when ShowCommand is set.
+func (d *Display) result(out io.Writer, cr *CommandResult) {
+	if d.ProblemsOnly && cr.Status != StatusBad && cr.Status != StatusWarn {
+		d.hidden++
+		return
+	}
+	if cr.Status == StatusBad && len(cr.Blame) > 0 {
+		fmt.Fprintln(out, bad("broke after editing "+listFiles(cr.Blame, 3)))
+	}
+	fmt.Fprintln(out, cr.String())
+	if d.ShowCommand && cr.Status == StatusBad && cr.Command != "" {
+		fmt.Fprintln(out, dim("  $ "+cr.Command))
+	}
+	if !d.LastGood {
+		return
+	}
+	key := cr.Dir + "\x00" + cr.Name
+	switch cr.Status {
+	case StatusOk:
+		if cr.Output != "" {
+			d.lastGood[key] = cr.Output
+		}
+	case StatusBad:
+		if output, found := d.lastGood[key]; found {
+			fmt.Fprintln(out, dim("  last passing output:"))
+			for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+				fmt.Fprintln(out, dim("  "+line))
+			}
+		}
+	}
+}
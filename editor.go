@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// editorFormats are how editors that don't take +line are told where to go, by command name.
+var editorFormats = map[string]string{
+	"code":      "--goto {file}:{line}:{col}",
+	"codium":    "--goto {file}:{line}:{col}",
+	"subl":      "{file}:{line}:{col}",
+	"hx":        "{file}:{line}:{col}",
+	"helix":     "{file}:{line}:{col}",
+	"zed":       "{file}:{line}:{col}",
+	"micro":     "+{line}:{col} {file}",
+	"gedit":     "+{line}:{col} {file}",
+	"notepad++": "-n{line} -c{col} {file}",
+}
+
+// editorCommand returns the command opening files at a line, with {file},
+// {line} and {col} to fill in: format if it's set, otherwise $VISUAL or
+// $EDITOR with the arguments it takes, +{line} {file} like vi unless it's known to differ.
+func editorCommand(format string) (string, error) {
+	if format != "" {
+		return format, nil
+	}
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	fields := strings.Fields(editor)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("set $EDITOR or -editor-cmd to open the files")
+	}
+	args, found := editorFormats[filepath.Base(fields[0])]
+	if !found {
+		args = "+{line} {file}"
+	}
+	return editor + " " + args, nil
+}
+
+// openError opens the file of the first error in results in the editor at its
+// line, returning once the editor is closed.
+func openError(results []CommandResult, format string) error {
+	var first *BuildError
+	var dir string
+	for _, cr := range results {
+		if cr.Status == StatusBad && len(cr.Errors) > 0 {
+			first, dir = &cr.Errors[0], cr.Dir
+			break
+		}
+	}
+	if first == nil {
+		return fmt.Errorf("there's no error to open")
+	}
+
+	command, err := editorCommand(format)
+	if err != nil {
+		return err
+	}
+	path := first.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	col := first.Col
+	if col == 0 {
+		col = 1
+	}
+	// Filled in word by word, so a path with spaces stays one argument.
+	replace := strings.NewReplacer("{file}", path, "{line}", strconv.Itoa(first.Line), "{col}", strconv.Itoa(col))
+	var args []string
+	for _, word := range strings.Fields(command) {
+		args = append(args, replace.Replace(word))
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
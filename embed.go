@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// embedPatterns returns the //go:embed patterns used by the go files in dir, joined onto dir.
+func embedPatterns(dir string) []string {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+
+	var patterns []string
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if !strings.HasPrefix(line, "//go:embed ") {
+				continue
+			}
+			for _, pattern := range strings.Fields(strings.TrimPrefix(line, "//go:embed ")) {
+				if unquoted, err := strconv.Unquote(pattern); err == nil {
+					pattern = unquoted
+				}
+				pattern = strings.TrimPrefix(pattern, "all:")
+				patterns = append(patterns, filepath.Join(dir, pattern))
+			}
+		}
+		f.Close()
+	}
+	return patterns
+}
+
+// embedDirs returns the directories that need watching to see changes to files matching patterns.
+func embedDirs(patterns []string) []string {
+	var dirs []string
+	for _, pattern := range patterns {
+		info, err := os.Stat(pattern)
+		if err == nil && info.IsDir() {
+			dirs = append(dirs, pattern)
+			continue
+		}
+		dirs = append(dirs, filepath.Dir(pattern))
+	}
+	return dirs
+}
+
+// matchEmbed reports whether path is one of the files matched by patterns, or inside a matched directory.
+func matchEmbed(path string, patterns []string) bool {
+	path = filepath.Clean(path)
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+		if strings.HasPrefix(path, pattern+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BuildError is a single error location reported by the go tool.
+type BuildError struct {
+	File    string
+	Line    int
+	Col     int
+	Message string
+}
+
+// errorLine matches "file.go:line:col: message" and "file.go:line: message".
+var errorLine = regexp.MustCompile(`^\s*([^\s:][^:]*\.go):(\d+)(?::(\d+))?: (.*)$`)
+
+// ParseBuildErrors extracts the error locations from go build or go test output.
+func ParseBuildErrors(output string) []BuildError {
+	var errs []BuildError
+	for _, line := range strings.Split(output, "\n") {
+		m := errorLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		be := BuildError{
+			File:    m[1],
+			Message: m[4],
+		}
+		be.Line, _ = strconv.Atoi(m[2])
+		if m[3] != "" {
+			be.Col, _ = strconv.Atoi(m[3])
+		}
+		errs = append(errs, be)
+	}
+	return errs
+}
+
+// compileErrors extracts the errors of compiling a package from the output of a
+// command that prints its own too, like go test or go run. Only those in the
+// block under a "# package" line count, not a t.Log or log line with its file:line.
+func compileErrors(output string) []BuildError {
+	var compiled []string
+	in := false
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case strings.HasPrefix(line, "# "):
+			in = true
+		case in && (strings.HasPrefix(line, "\t") || errorLine.MatchString(line) && !strings.HasPrefix(line, " ")):
+			compiled = append(compiled, line)
+		default:
+			in = false
+		}
+	}
+	return ParseBuildErrors(strings.Join(compiled, "\n"))
+}
+
+// String formats the error the way editors expect a quickfix entry.
+func (be BuildError) String() string {
+	if be.Col == 0 {
+		return fmt.Sprintf("%s:%d: %s", be.File, be.Line, be.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", be.File, be.Line, be.Col, be.Message)
+}
+
+// Position formats where in its file the error is, as line:col or just line.
+func (be BuildError) Position() string {
+	if be.Col == 0 {
+		return strconv.Itoa(be.Line)
+	}
+	return fmt.Sprintf("%d:%d", be.Line, be.Col)
+}
+
+// groupByFile splits errs up by file, sorted by path and then position.
+func groupByFile(errs []BuildError) [][]BuildError {
+	sorted := append([]BuildError(nil), errs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].File != sorted[j].File {
+			return sorted[i].File < sorted[j].File
+		}
+		if sorted[i].Line != sorted[j].Line {
+			return sorted[i].Line < sorted[j].Line
+		}
+		return sorted[i].Col < sorted[j].Col
+	})
+
+	var groups [][]BuildError
+	for _, be := range sorted {
+		if n := len(groups); n > 0 && groups[n-1][0].File == be.File {
+			groups[n-1] = append(groups[n-1], be)
+			continue
+		}
+		groups = append(groups, []BuildError{be})
+	}
+	return groups
+}
+
+// errorKinds are go tool errors needing a different kind of fix than the code
+// they're reported for, recognised by a phrase of their message.
+var errorKinds = []struct {
+	phrase string
+	hint   string
+}{
+	{"import cycle not allowed", "import cycle: move what both packages need into a package of its own"},
+	{"no required module provides package", "missing module: go get it, or run go mod tidy"},
+	{"missing go.sum entry", "missing go.sum entry: run go mod tidy"},
+	{"updates to go.mod needed", "go.mod is out of date: run go mod tidy"},
+	{"ambiguous import", "ambiguous import: the package is in more than one module, drop one from go.mod"},
+	{"cannot find module providing package", "missing module: go get it, or run go mod tidy"},
+}
+
+// ErrorHints describes the kinds of go tool errors in output that aren't ordinary compile errors.
+func ErrorHints(output string) []string {
+	var hints []string
+	seen := map[string]bool{}
+	for _, kind := range errorKinds {
+		if strings.Contains(output, kind.phrase) && !seen[kind.hint] {
+			seen[kind.hint] = true
+			hints = append(hints, kind.hint)
+		}
+	}
+	return hints
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestErrorHints(t *testing.T) {
+	tests := []struct {
+		output string
+		want   []string
+	}{
+		{"./main.go:3:2: undefined: x\n", nil},
+		{"package a\n\timports b\n\timports a: import cycle not allowed\n", []string{"import cycle: move what both packages need into a package of its own"}},
+		{"main.go:4:2: no required module provides package example.com/x; to add it:\n", []string{"missing module: go get it, or run go mod tidy"}},
+		{"main.go:4:2: cannot find module providing package example.com/x\n", []string{"missing module: go get it, or run go mod tidy"}},
+		{"main.go:4:2: missing go.sum entry for module providing package example.com/x\n", []string{"missing go.sum entry: run go mod tidy"}},
+		{"go: updates to go.mod needed; to update it:\n", []string{"go.mod is out of date: run go mod tidy"}},
+		{"main.go:4:2: ambiguous import: found package example.com/x in multiple modules\n", []string{"ambiguous import: the package is in more than one module, drop one from go.mod"}},
+		{
+			// The same hint only once, each kind in the order errorKinds lists them.
+			"a: no required module provides package x\nb: cannot find module providing package y\nimport cycle not allowed\n",
+			[]string{"import cycle: move what both packages need into a package of its own", "missing module: go get it, or run go mod tidy"},
+		},
+	}
+	for _, test := range tests {
+		if got := ErrorHints(test.output); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ErrorHints(%q) = %q, want %q", test.output, got, test.want)
+		}
+	}
+}
+
+func TestParseBuildErrors(t *testing.T) {
+	tests := []struct {
+		output string
+		want   []BuildError
+	}{
+		{"", nil},
+		{"# example.com/m\n./main.go:3:2: undefined: x\n", []BuildError{{"./main.go", 3, 2, "undefined: x"}}},
+		{"a/a.go:12: missing return\n", []BuildError{{"a/a.go", 12, 0, "missing return"}}},
+		{"go: downloading example.com/x v1.0.0\nok  \texample.com/m\t0.01s\n", nil},
+		{
+			"./b.go:1:1: first\n\thave (int)\n./a.go:2:3: second\n",
+			[]BuildError{{"./b.go", 1, 1, "first"}, {"./a.go", 2, 3, "second"}},
+		},
+	}
+	for _, test := range tests {
+		if got := ParseBuildErrors(test.output); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseBuildErrors(%q) = %v, want %v", test.output, got, test.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []BuildError
+	}{
+		{
+			"failing test",
+			"=== RUN   TestFoo\n    foo_test.go:12: got 1 want 2\n--- FAIL: TestFoo (0.00s)\nFAIL\nFAIL\texample.com/m\t0.01s\n",
+			nil,
+		},
+		{
+			"app logging",
+			"main.go:20: listening on :8080\n",
+			nil,
+		},
+		{
+			"test that doesn't compile",
+			"# example.com/m [example.com/m.test]\n./foo_test.go:5:2: undefined: x\n./foo_test.go:6:2: declared and not used: y\nFAIL\texample.com/m [build failed]\n",
+			[]BuildError{{"./foo_test.go", 5, 2, "undefined: x"}, {"./foo_test.go", 6, 2, "declared and not used: y"}},
+		},
+		{
+			"continued message",
+			"# example.com/m\n./a.go:3:9: cannot use x\n\thave int\n./a.go:4:1: missing return\nstarting\n./a.go:9: listening\n",
+			[]BuildError{{"./a.go", 3, 9, "cannot use x"}, {"./a.go", 4, 1, "missing return"}},
+		},
+	}
+	for _, test := range tests {
+		if got := compileErrors(test.output); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: compileErrors = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestGroupByFile(t *testing.T) {
+	errs := []BuildError{
+		{"b.go", 3, 1, "b3"},
+		{"a.go", 7, 0, "a7"},
+		{"b.go", 1, 5, "b1:5"},
+		{"a.go", 2, 4, "a2"},
+		{"b.go", 1, 2, "b1:2"},
+	}
+	want := [][]BuildError{
+		{{"a.go", 2, 4, "a2"}, {"a.go", 7, 0, "a7"}},
+		{{"b.go", 1, 2, "b1:2"}, {"b.go", 1, 5, "b1:5"}, {"b.go", 3, 1, "b3"}},
+	}
+	if got := groupByFile(errs); !reflect.DeepEqual(got, want) {
+		t.Errorf("groupByFile = %v, want %v", got, want)
+	}
+	if errs[0].File != "b.go" {
+		t.Errorf("groupByFile sorted the errors it was given")
+	}
+	if got := groupByFile(nil); got != nil {
+		t.Errorf("groupByFile(nil) = %v, want nil", got)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+// fileEvent is a change printed by -events-only -events-json.
+type fileEvent struct {
+	Time time.Time `json:"time"`
+	Op   string    `json:"op"`
+	Name string    `json:"name"`
+}
+
+// watchEvents is -events-only: instead of building, it prints the changes to
+// the files that would have started a build, until interrupted.
+func watchEvents(cfg Config, out, eout io.Writer, watcher *fsnotify.Watcher) error {
+	added := make(chan string)
+	walked := make(chan walkResult)
+	recent := recentEvents{}
+	hashes := contentCache{}
+
+	dirs := cfg.Modules
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+	var watched []string
+	for _, dir := range dirs {
+		hashes.addDir(dir)
+		roots := []string{dir}
+		if len(cfg.Watch) > 0 {
+			roots = watchRoots(dir, cfg.Watch)
+		}
+		for _, root := range roots {
+			err := watcher.Add(root)
+			if err != nil {
+				return err
+			}
+			watched = append(watched, root)
+			maxDepth, _ := treeDepth(cfg.MaxDepth, watched, root)
+			watchTree(watcher, root, maxDepth, added, walked)
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	enc := json.NewEncoder(out)
+	for {
+		select {
+		case <-signals:
+			return nil
+		case <-added:
+		case walk := <-walked:
+			if walk.err != nil {
+				fmt.Fprintln(eout, "error:", walk.err)
+			}
+		case err := <-watcher.Errors:
+			fmt.Fprintln(eout, "error:", err)
+		case ev := <-watcher.Events:
+			if ev.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() && !ignored(ev.Name, cfg.Ignore) {
+					if maxDepth, ok := treeDepth(cfg.MaxDepth, watched, ev.Name); ok {
+						watchTree(watcher, ev.Name, maxDepth, added, walked)
+					}
+					continue
+				}
+			}
+			if ignored(ev.Name, cfg.Ignore) || recent.seen(ev.Name) {
+				continue
+			}
+			if unwatched(cfg, dirs, ev.Name, false) != "" {
+				continue
+			}
+			if !hashes.changed(ev.Name) {
+				continue
+			}
+
+			if cfg.EventsJSON {
+				enc.Encode(fileEvent{Time: time.Now(), Op: ev.Op.String(), Name: filepath.Clean(ev.Name)})
+			} else {
+				fmt.Fprintln(out, ev.Op, filepath.Clean(ev.Name))
+			}
+		}
+	}
+}
+
+// unwatched returns why a change to name, in one of the modules in dirs, doesn't
+// start a build, or "" if it does. used is whether the module is known to use the
+// file anyway, embedding it or generating code from it.
+func unwatched(cfg Config, dirs []string, name string, used bool) string {
+	switch {
+	case used || isModFile(name) || inTestdata(name) || testOnly(dirs, name, cfg.TestOnly):
+		return ""
+	case strings.HasSuffix(name, ".go"):
+		if !cfg.WatchGen && isGenerated(name) {
+			// Regenerated by the build itself, e.g. protobuf code, so it would only build again.
+			return "generated code, -watch-generated watches it"
+		}
+		return ""
+	case includedIn(dirs, name, cfg.Include):
+		return ""
+	}
+	return "not a go file, -include adds other files"
+}
+
+// includedIn reports whether path is matched by the -include globs of any of the modules in dirs.
+func includedIn(dirs []string, path string, globs []string) bool {
+	for _, dir := range dirs {
+		if included(dir, path, globs) {
+			return true
+		}
+	}
+	return false
+}
+
+// testOnly reports whether path is matched by the -test-only patterns of any of the modules in dirs.
+func testOnly(dirs []string, path string, patterns []string) bool {
+	for _, dir := range dirs {
+		if inTestOnly(dir, path, patterns) {
+			return true
+		}
+	}
+	return false
+}
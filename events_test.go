@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnwatched(t *testing.T) {
+	dir := t.TempDir()
+	generated := filepath.Join(dir, "gen.pb.go")
+	err := os.WriteFile(generated, []byte("// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage gen\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{Include: []string{"templates/**"}, TestOnly: []string{"fixtures/*"}}
+
+	tests := []struct {
+		name    string
+		used    bool
+		watched bool
+	}{
+		{"main.go", false, true},
+		{"main_test.go", false, true},
+		{"go.mod", false, true},
+		{"go.sum", false, true},
+		{"testdata/in.json", false, true},
+		{"fixtures/in.json", false, true},
+		{"templates/page.html", false, true},
+		{"README.md", false, false},
+		{"static/logo.png", true, true},
+		{"gen.pb.go", false, false},
+	}
+	for _, test := range tests {
+		why := unwatched(cfg, []string{dir}, filepath.Join(dir, test.name), test.used)
+		if (why == "") != test.watched {
+			t.Errorf("unwatched(%q) = %q, want watched %v", test.name, why, test.watched)
+		}
+	}
+
+	cfg.WatchGen = true
+	if why := unwatched(cfg, []string{dir}, generated, false); why != "" {
+		t.Errorf("generated code is unwatched with -watch-generated: %q", why)
+	}
+}
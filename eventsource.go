@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventSource runs the -events-from command in place of watching the modules,
+// each line it prints is the path of a file that changed.
+type eventSource struct {
+	args []string
+	eout io.Writer
+
+	lock    sync.Mutex
+	cmd     *exec.Cmd
+	stopped bool
+}
+
+// startEventSource starts command, sending the paths it prints to paths. If it
+// stops it's restarted with keepAlive, otherwise the error is sent to failed.
+func startEventSource(command string, useShell bool, paths chan<- string, eout io.Writer, keepAlive bool, failed chan<- error) (*eventSource, error) {
+	es := &eventSource{args: commandArgs(command, useShell), eout: eout}
+	if len(es.args) == 0 {
+		return nil, fmt.Errorf("-events-from needs a command to run")
+	}
+	if _, err := exec.LookPath(es.args[0]); err != nil {
+		return nil, fmt.Errorf("-events-from: %v", err)
+	}
+
+	go func() {
+		for {
+			err := es.run(paths)
+			es.lock.Lock()
+			stopped := es.stopped
+			es.lock.Unlock()
+			if stopped {
+				return
+			}
+			if err == nil {
+				err = fmt.Errorf("it exited")
+			}
+			if !keepAlive {
+				select {
+				case failed <- fmt.Errorf("-events-from command stopped: %v", err):
+				default:
+				}
+				return
+			}
+			logger.Error("the -events-from command stopped, restarting it", "err", err)
+			fmt.Fprintln(eout, "error: -events-from command stopped, restarting it:", err)
+			time.Sleep(time.Second)
+		}
+	}()
+	return es, nil
+}
+
+// run runs the command once, until it exits.
+func (es *eventSource) run(paths chan<- string) error {
+	cmd := exec.Command(es.args[0], es.args[1:]...)
+	cmd.Stderr = es.eout
+	ownGroup(cmd)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	es.lock.Lock()
+	if es.stopped {
+		es.lock.Unlock()
+		return nil
+	}
+	err = cmd.Start()
+	es.cmd = cmd
+	es.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	logger.Info("reading events", "cmd", cmd.Args)
+
+	lines := bufio.NewScanner(stdout)
+	for lines.Scan() {
+		if path := strings.TrimSpace(lines.Text()); path != "" {
+			paths <- localPath(path)
+		}
+	}
+	return cmd.Wait()
+}
+
+// localPath makes an absolute path relative to the working directory, like the
+// paths of the watcher's events, so it's matched to its module the same way.
+func localPath(path string) string {
+	if !filepath.IsAbs(path) {
+		return path
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(wd, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// Stop kills the command, it isn't restarted.
+func (es *eventSource) Stop() {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	es.stopped = true
+	if es.cmd != nil && es.cmd.Process != nil {
+		killGroup(es.cmd.Process.Pid)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// readFocus returns the absolute paths of the files listed one per line in the focus file,
+// relative ones to its directory. A missing focus file is the same as an empty one.
+func readFocus(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	active := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			// Relative to the focus file, not wherever gowatch was started.
+			line = filepath.Join(filepath.Dir(path), line)
+		}
+		abs, err := filepath.Abs(line)
+		if err != nil {
+			return nil, err
+		}
+		active[abs] = true
+	}
+	return active, scanner.Err()
+}
+
+// relativePackage returns the package in pkgDir as a ./ path relative to dir,
+// both absolute. It returns false if pkgDir isn't below dir.
+func relativePackage(dir, pkgDir string) (string, bool) {
+	rel, err := filepath.Rel(dir, pkgDir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	if rel == "." {
+		return ".", true
+	}
+	return "./" + filepath.ToSlash(rel), true
+}
+
+// packagesOf returns the packages containing files, as ./ paths relative to dir.
+func packagesOf(dir string, files map[string]bool) []string {
+	// The files are absolute, so dir has to be too.
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	var pkgs []string
+	for file := range files {
+		pkg, ok := relativePackage(dir, filepath.Dir(file))
+		if ok && !seen[pkg] {
+			seen[pkg] = true
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	sort.Strings(pkgs)
+	return pkgs
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadFocus(t *testing.T) {
+	dir := t.TempDir()
+	focus := filepath.Join(dir, "editor", "focus")
+	other := filepath.Join(dir, "other.go")
+	writeFile(t, focus, "../a/a.go\n\n"+other+"\n")
+	// Not where it's read from.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(os.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	active, err := readFocus(focus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{filepath.Join(dir, "a", "a.go"), other} {
+		if !active[want] {
+			t.Errorf("readFocus = %v, missing %s", active, want)
+		}
+	}
+	if len(active) != 2 {
+		t.Errorf("readFocus = %v, want 2 files", active)
+	}
+
+	if active, err := readFocus(filepath.Join(dir, "missing")); err != nil || active != nil {
+		t.Errorf("readFocus of a missing file = %v, %v, want nothing", active, err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gitChanges returns the absolute paths of the .go files below dir that differ
+// from the last commit in git, staged or not, and the new ones git doesn't track
+// yet, for -git-scope.
+func gitChanges(dir string) (map[string]bool, error) {
+	changed, err := diffedFiles(dir, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	return changed, untrackedFiles(dir, changed)
+}
+
+// sinceChanges returns the absolute paths of the .go files below dir changed
+// since the branch left ref, in the commits made since and in the working tree,
+// for -since. It's the changes git diff ref...HEAD lists, the uncommitted ones and
+// the new files git doesn't track yet.
+func sinceChanges(dir, ref string) (map[string]bool, error) {
+	base, err := git(dir, "merge-base", ref, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	changed, err := diffedFiles(dir, strings.TrimSpace(base))
+	if err != nil {
+		return nil, err
+	}
+	return changed, untrackedFiles(dir, changed)
+}
+
+// refsSettle is how long the git refs must stay the same after a commit or
+// checkout before the changes since the -since ref are worked out again.
+const refsSettle = 500 * time.Millisecond
+
+// gitDir returns the absolute path of the .git directory of the repository dir is in.
+func gitDir(dir string) (string, error) {
+	out, err := git(dir, "rev-parse", "--absolute-git-dir")
+	return strings.TrimSpace(out), err
+}
+
+// inGitDir reports whether path is in the git directory gitDir.
+func inGitDir(gitDir, path string) bool {
+	rel, err := filepath.Rel(gitDir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// isRefChange reports whether path, in the git directory gitDir, is where git
+// keeps what HEAD and the branches point to, which a commit or checkout changes.
+func isRefChange(gitDir, path string) bool {
+	rel, err := filepath.Rel(gitDir, path)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	return rel == "HEAD" || rel == "packed-refs" || strings.HasPrefix(rel, "refs/")
+}
+
+// diffedFiles returns the absolute paths of the .go files below dir that differ from commit.
+func diffedFiles(dir, commit string) (map[string]bool, error) {
+	out, err := git(dir, "diff", "--name-only", "--relative", commit)
+	if err != nil {
+		return nil, err
+	}
+	changed := map[string]bool{}
+	return changed, addGoFiles(dir, out, changed)
+}
+
+// untrackedFiles adds the absolute paths of the .go files below dir that git
+// doesn't track yet, and doesn't ignore, to changed. git diff doesn't list them.
+func untrackedFiles(dir string, changed map[string]bool) error {
+	out, err := git(dir, "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return err
+	}
+	return addGoFiles(dir, out, changed)
+}
+
+// addGoFiles adds the absolute paths of the .go files git listed relative to dir to files.
+func addGoFiles(dir, listed string, files map[string]bool) error {
+	for _, line := range strings.Split(listed, "\n") {
+		if !strings.HasSuffix(line, ".go") {
+			continue
+		}
+		abs, err := filepath.Abs(filepath.Join(dir, line))
+		if err != nil {
+			return err
+		}
+		files[abs] = true
+	}
+	return nil
+}
+
+// git runs git with args in dir, returning what it prints.
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		if exit, ok := err.(*exec.ExitError); ok && len(exit.Stderr) > 0 {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(exit.Stderr)))
+		}
+		return "", fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitRepo makes a git repository in a temporary directory with files committed.
+func gitRepo(t *testing.T, files ...string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	dir := t.TempDir()
+	for _, file := range files {
+		writeFile(t, filepath.Join(dir, file), "package main\n")
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-qm", "initial"},
+	} {
+		if _, err := git(dir, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err == nil {
+		err = os.WriteFile(path, []byte(content), 0o644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGitChanges(t *testing.T) {
+	dir := gitRepo(t, "main.go", "lib/lib.go", "other/other.go")
+	writeFile(t, filepath.Join(dir, "lib/lib.go"), "package lib\n")
+	writeFile(t, filepath.Join(dir, "new/new.go"), "package new\n")
+	writeFile(t, filepath.Join(dir, "notes.txt"), "not go\n")
+	writeFile(t, filepath.Join(dir, ".gitignore"), "ignored/\n")
+	writeFile(t, filepath.Join(dir, "ignored/ignored.go"), "package ignored\n")
+
+	changed, err := gitChanges(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := packagesOf(dir, changed)
+	want := []string{"./lib", "./new"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("packages changed = %v, want %v", got, want)
+	}
+}
+
+func TestSinceChanges(t *testing.T) {
+	dir := gitRepo(t, "main.go", "lib/lib.go", "other/other.go")
+	if _, err := git(dir, "branch", "base"); err != nil {
+		t.Fatal(err)
+	}
+	m := &Module{Dir: dir, Root: dir, edited: map[string]bool{}}
+	var err error
+	m.since, err = sinceChanges(dir, "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.sincePackages(); got == nil || len(got) != 0 {
+		t.Errorf("packages changed before any change = %#v, want none", got)
+	}
+
+	writeFile(t, filepath.Join(dir, "lib/lib.go"), "package lib\n")
+	if _, err := git(dir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-qam", "lib"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "new/new.go"), "package new\n")
+	m.since, err = sinceChanges(dir, "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.edited[filepath.Join(dir, "other/other.go")] = true
+	got := m.sincePackages()
+	want := []string{"./lib", "./new", "./other"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("packages changed = %v, want %v", got, want)
+	}
+}
+
+func TestIsRefChange(t *testing.T) {
+	gitDir := filepath.Join("repo", ".git")
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"HEAD", true},
+		{"packed-refs", true},
+		{"refs/heads/main", true},
+		{"index", false},
+		{"HEAD.lock", false},
+		{"logs/HEAD", false},
+	}
+	for _, test := range tests {
+		if got := isRefChange(gitDir, filepath.Join(gitDir, test.path)); got != test.want {
+			t.Errorf("isRefChange(%q) = %v, want %v", test.path, got, test.want)
+		}
+	}
+	if inGitDir(gitDir, filepath.Join("repo", "main.go")) {
+		t.Errorf("inGitDir of a file outside .git = true, want false")
+	}
+}
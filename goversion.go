@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// goVersion returns the version of the go binary that runs in dir, e.g. go1.22.1,
+// which a toolchain line in go.mod may change. It's empty if go version fails.
+func goVersion(binary, dir string) string {
+	cmd := exec.Command(binary, "version")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		logger.Error("getting the go version", "dir", dir, "err", err)
+		return ""
+	}
+	// go version go1.22.1 linux/amd64
+	fields := strings.Fields(string(out))
+	if len(fields) < 3 {
+		return ""
+	}
+	return fields[2]
+}
+
+// versionResult is the go version found for a module with -show-go.
+type versionResult struct {
+	m       *Module
+	version string
+}
+
+// checkGoVersion finds the go version of m again in the background. A
+// toolchain line in go.mod can make go version download that toolchain first.
+func (s *session) checkGoVersion(m *Module) {
+	binary := goCommand
+	go func() {
+		s.versions <- versionResult{m, goVersion(binary, m.Root)}
+	}()
+}
+
+// goVersions lists the go versions of the modules, each only once.
+func goVersions(modules []*Module) []string {
+	var versions []string
+	seen := map[string]bool{}
+	for _, m := range modules {
+		if m.goVersion != "" && !seen[m.goVersion] {
+			seen[m.goVersion] = true
+			versions = append(versions, m.goVersion)
+		}
+	}
+	return versions
+}
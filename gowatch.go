@@ -2,81 +2,1220 @@ package main
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"gopkg.in/fatih/color.v0"
 	"gopkg.in/fsnotify.v1"
 )
 
-func main() {
-	Main(os.Stdout, os.Stderr)
+// Config holds the options set on the command line or in the config file.
+type Config struct {
+	Pipe         string
+	ModDownload  bool
+	StartupDelay time.Duration
+	Modules      []string
+	BuildCmd     string
+	TestCmd      string
+	Shell        bool
+	MinInterval  time.Duration
+	Focus        string
+	Debug        bool
+	LogLevel     string
+	LogFile      string
+	Size         bool
+	Embeds       []string
+	SoundOk      string
+	SoundFail    string
+	StatusFile   string
+	Ignore       []string
+	NoIgnore     bool
+	Color        string
+	BuildFirst   bool
+	Metrics      string
+	NoClear      bool
+	Separator    string
+	Strict       bool
+	Shuffle      string
+	Interval     time.Duration
+	ConfigFile   string
+	InPlace      bool
+	TriggerFile  string
+	Watch        []string
+	ShowCommand  bool
+	Incremental  bool
+	MaxJobs      int
+	KeepScreen   bool
+	ExecPrefix   string
+	RerunFails   bool
+	LogSize      int
+	LogKeep      int
+	EventsOnly   bool
+	EventsJSON   bool
+	NoCache      bool
+	Badge        bool
+	Serial       bool
+	FailFast     bool
+	Root         string
+	Socket       string
+	RunCmd       string
+	Proxy        string
+	App          string
+	MaxDepth     int
+	Completion   string
+	BuildVerbose bool
+	Preset       string
+	NoInitial    bool
+	Targets      []string
+	LastGood     bool
+	HealthFile   string
+	HealthAge    time.Duration
+	Examples     bool
+	TestOnly     []string
+	SameFails    bool
+	SetupCmd     string
+	TeardownCmd  string
+	Truncate     bool
+	Affected     bool
+	SkipSame     bool
+	PerPackage   bool
+	Totals       bool
+	Links        string
+	LinkFormat   string
+	RebuildSig   string
+	ReloadSig    string
+	Output       string
+	NotifyLevel  string
+	WatchRefresh time.Duration
+	HTML         string
+	Include      []string
+	KeepAlive    bool
+	Warm         bool
+	CI           string
+	WatchGen     bool
+	CoverProfile string
+	Syslog       bool
+	Summary      bool
+	EventsFrom   string
+	NotifyEvery  time.Duration
+	File         string
+	ShowChange   bool
+	Nice         int
+	PrintPlan    bool
+	Mod          string
+	QuickDelay   time.Duration
+	FullDelay    time.Duration
+	KeepOthers   bool
+	GitScope     bool
+	Celebrate    string
+	MainOnly     string
+	ShowGo       bool
+	OnChange     string
+	TAP          bool
+	MaxProcs     int
+	Observe      bool
+	Tidy         bool
+	ShowKilled   bool
+	JUnit        string
+	Restore      bool
+	Settle       time.Duration
+	Workspace    bool
+	Columns      bool
+	Go           string
+	CollapsePass bool
+	Blame        bool
+	Tail         int
+	Race         bool
+	SkipTests    []string
+	EditorCmd    string
+	Rollup       bool
+	Notify       bool
+	Since        string
+	Markdown     string
+	ProblemsOnly bool
+
+	// TestArgs are added to the test command, they're given after -- on the command line.
+	TestArgs []string
+
+	// Colors maps the parts of the output (ok, bad, ...) to color names.
+	Colors map[string]string
+
+	// Rules are the extra commands to run when some files change.
+	Rules []Rule
+
+	// Presets are named sets of options, applied on top of the others with -preset.
+	Presets map[string]map[string]interface{}
+
+	// commandLine are the flags set on the command line, presets don't change them.
+	commandLine map[string]bool
+	// args is the command line, to read the config file again with.
+	args []string
+	// healthcheck is set when gowatch was run as "gowatch healthcheck".
+	healthcheck bool
+
+	// Running are the messages shown in place of the earlier output while each command runs.
+	Running map[string]string
+
+	// Themes override the icons and colors of the statuses of some commands, by name.
+	Themes map[string]Theme
+
+	// Labels are the names to show some commands with instead, and Tags the
+	// shorter ones used in the status file, by command name.
+	Labels map[string]string
+	Tags   map[string]string
+
+	// Commands are the timeouts and retries of some commands, by name.
+	Commands map[string]CommandPolicy
+
+	// Groups are directories watched besides the modules, with actions of their own.
+	Groups []WatchGroup
+
+	// Order lists the results to show first, the others follow in their usual order.
+	Order []string
+}
+
+// stringsFlag is a flag.Value that collects every use of a repeated flag.
+type stringsFlag []string
+
+func (sf *stringsFlag) String() string {
+	return strings.Join(*sf, ",")
 }
 
-func clear(eout io.Writer) {
-	cmd := exec.Command("clear")
-	cmd.Stdout = os.Stdout
+// Set appends the value.
+func (sf *stringsFlag) Set(value string) error {
+	*sf = append(*sf, value)
+	return nil
+}
+
+// defineFlags defines the command line flags on fs, storing their values in cfg.
+func defineFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.Pipe, "pipe", "", "also write errors as file:line:col: message to the FIFO at `path`")
+	fs.BoolVar(&cfg.ModDownload, "mod-download", false, "run go mod download before rebuilding when go.mod or go.sum change")
+	fs.DurationVar(&cfg.StartupDelay, "startup-delay", 0, "wait this long before the initial build, a file change first skips it")
+	fs.Var((*stringsFlag)(&cfg.Modules), "module", "watch and build the module in `dir` in its own block, may be repeated")
+	fs.StringVar(&cfg.BuildCmd, "build", "go build ./...", "the build `command`")
+	fs.StringVar(&cfg.TestCmd, "test", "go test -v ./...", "the test `command`")
+	fs.BoolVar(&cfg.Shell, "shell", false, "always run the commands through $SHELL, otherwise only when they use shell syntax")
+	fs.DurationVar(&cfg.MinInterval, "min-interval", 0, "start builds at most once per `interval`, changes in between are combined into one later build")
+	fs.StringVar(&cfg.Focus, "focus", "", "only react to changes to the files listed in `file`, building just their packages")
+	fs.BoolVar(&cfg.Debug, "debug", false, "log what gowatch is doing, the same as -log-level debug")
+	fs.StringVar(&cfg.LogLevel, "log-level", "", "log gowatch's own activity at `level` (info or debug)")
+	fs.StringVar(&cfg.LogFile, "log", "", "write the log to `file` instead of stderr")
+	fs.BoolVar(&cfg.Size, "size", false, "build into a temporary directory and show the size of the binaries")
+	fs.Var((*stringsFlag)(&cfg.Embeds), "embed", "also rebuild when files matching `glob` (relative to the module) change, may be repeated")
+	fs.StringVar(&cfg.SoundOk, "sound-ok", "", "play the sound `file` when everything passes again")
+	fs.StringVar(&cfg.SoundFail, "sound-fail", "", "play the sound `file` when something starts failing")
+	fs.StringVar(&cfg.StatusFile, "status-file", "", "keep a one line summary like \"gowatch: ✔build ✘test\" in `file` for status bars")
+	fs.Var((*stringsFlag)(&cfg.Ignore), "ignore", "ignore changes to files whose name matches `glob`, may be repeated")
+	fs.BoolVar(&cfg.NoIgnore, "no-default-ignore", false, "don't ignore editor swap, backup and lock files")
+	fs.StringVar(&cfg.Color, "color", "auto", "use colors `always`, never or auto (only on a terminal)")
+	fs.BoolVar(&cfg.BuildFirst, "build-first", false, "only run the tests once the build passes")
+	fs.StringVar(&cfg.Metrics, "metrics", "", "serve Prometheus metrics on `addr`ess, e.g. :9090")
+	fs.BoolVar(&cfg.NoClear, "no-clear", false, "keep the earlier results on screen instead of clearing it")
+	fs.StringVar(&cfg.Separator, "separator", "──────── {time} ────────", "the `line` between results with -no-clear, {time} is replaced with the time")
+	fs.BoolVar(&cfg.Strict, "strict", false, "run go vet after each build and fail the build on any findings")
+	fs.StringVar(&cfg.Shuffle, "shuffle", "", "shuffle the order tests run in, `on` or a seed, the seed of any failure is shown")
+	fs.DurationVar(&cfg.Interval, "interval", 0, "also rebuild every `interval` whether or not anything changed")
+	fs.BoolVar(&cfg.InPlace, "in-place", false, "redraw over the previous results instead of clearing the screen, keeping the scrollback")
+	fs.StringVar(&cfg.TriggerFile, "trigger-file", "", "only build when `file` is touched, ignoring changes to everything else")
+	fs.Var((*stringsFlag)(&cfg.Watch), "watch", "only watch the directory tree at `dir`, may be repeated")
+	fs.BoolVar(&cfg.ShowCommand, "show-command", false, "show the full command line of failed commands, to run them yourself")
+	fs.BoolVar(&cfg.Incremental, "incremental", false, "with -strict only vet the packages of the changed files")
+	fs.IntVar(&cfg.MaxJobs, "max-jobs", 0, "run at most `n` commands at once, 0 for no limit")
+	fs.BoolVar(&cfg.KeepScreen, "no-initial-clear", false, "don't clear the screen for the first results, keeping what was there")
+	fs.StringVar(&cfg.ExecPrefix, "exec-prefix", "", "run every command through `prefix`, e.g. \"docker compose run app\"")
+	fs.BoolVar(&cfg.RerunFails, "rerun-fails", false, "first run just the tests that failed last time, then everything once they pass")
+	fs.IntVar(&cfg.LogSize, "log-size", 0, "start a new -log file once it reaches `MB` megabytes, 0 to let it grow")
+	fs.IntVar(&cfg.LogKeep, "log-keep", 3, "keep this many old -log files with -log-size")
+	fs.BoolVar(&cfg.EventsOnly, "events-only", false, "never build, just print the changes that would have started a build")
+	fs.BoolVar(&cfg.EventsJSON, "events-json", false, "print the -events-only changes as JSON, one object per line")
+	fs.BoolVar(&cfg.NoCache, "no-cache", false, "always run the tests afresh instead of reusing cached results (go test -count=1)")
+	fs.BoolVar(&cfg.Badge, "badge", false, "only show a big PASS or FAIL, and the output of whatever failed")
+	fs.BoolVar(&cfg.Serial, "serial", false, "run the tests after the build instead of alongside it")
+	fs.BoolVar(&cfg.FailFast, "fail-fast", false, "run the tests after the build, and not at all if it fails (implies -serial)")
+	fs.StringVar(&cfg.Root, "root", "", "run the commands in `dir` instead of the watched directory")
+	fs.StringVar(&cfg.Socket, "socket", "", "send JSON events about triggers, starts and results to clients of the Unix socket at `path`")
+	fs.StringVar(&cfg.RunCmd, "run", "", "restart `command` after each passing build, e.g. the server being built")
+	fs.StringVar(&cfg.Proxy, "proxy", "", "serve a live reloading proxy for the -app on `addr`ess, refreshing the page after each restart")
+	fs.StringVar(&cfg.App, "app", "", "the `addr`ess the -run command serves HTTP on, for -proxy")
+	fs.IntVar(&cfg.MaxDepth, "max-depth", 0, "only watch directories up to `n` levels below the watched ones, 0 for no limit")
+	fs.StringVar(&cfg.Completion, "completion", "", "print a script completing gowatch's flags for `shell` (bash, zsh or fish) and exit")
+	fs.BoolVar(&cfg.BuildVerbose, "build-verbose", false, "build with -x -v to show what the go tool runs")
+	fs.StringVar(&cfg.Preset, "preset", "", "use the options of the `preset` of that name in the config file")
+	fs.BoolVar(&cfg.NoInitial, "no-initial", false, "don't build on startup, only once something changes")
+	fs.Var((*stringsFlag)(&cfg.Targets), "target", "also build for the `os/arch` platform, e.g. darwin/arm64, may be repeated")
+	fs.BoolVar(&cfg.LastGood, "last-good", false, "show the output of the last passing run under a failure, to compare")
+	fs.StringVar(&cfg.HealthFile, "health-file", "", "keep writing the time to `file` while watching, for gowatch healthcheck")
+	fs.DurationVar(&cfg.HealthAge, "health-max-age", 30*time.Second, "how recently the -health-file must have been written for gowatch healthcheck to pass")
+	fs.BoolVar(&cfg.Examples, "examples", false, "also run the runnable examples (go test -run ^Example) on a line of their own")
+	fs.Var((*stringsFlag)(&cfg.TestOnly), "test-only", "only run the tests when files matching `glob` (relative to the module, a directory matches everything below it) change, may be repeated")
+	fs.BoolVar(&cfg.SameFails, "collapse-same", false, "collapse the test output to a line while the same tests keep failing")
+	fs.StringVar(&cfg.SetupCmd, "setup-cmd", "", "run `command` once before watching, gowatch exits if it fails")
+	fs.StringVar(&cfg.TeardownCmd, "teardown-cmd", "", "run `command` once when gowatch exits")
+	fs.BoolVar(&cfg.Truncate, "truncate", false, "cut lines down to the width of the terminal instead of wrapping them")
+	fs.BoolVar(&cfg.Affected, "affected", false, "only build and test the packages of the changed files and the packages depending on them")
+	fs.BoolVar(&cfg.SkipSame, "skip-same", false, "only redraw after a change once a command's result differs from last time")
+	fs.BoolVar(&cfg.PerPackage, "per-package", false, "test each package on its own, showing a line for each")
+	fs.BoolVar(&cfg.Totals, "totals", false, "show how many builds and test runs there have been and how long they took in total, also on exit")
+	fs.StringVar(&cfg.Links, "links", "auto", "make error locations clickable links: always, auto (on terminals known to support them) or never")
+	fs.StringVar(&cfg.LinkFormat, "link-format", "file://{file}", "the `url` error locations link to, {file}, {line} and {col} are filled in")
+	fs.StringVar(&cfg.RebuildSig, "rebuild-signal", "USR1", "rebuild everything straight away on this `signal`, empty for none")
+	fs.StringVar(&cfg.ReloadSig, "reload-signal", "USR2", "read the config file again on this `signal`, empty for none")
+	fs.StringVar(&cfg.Output, "output", "", "build the binary to `path` (go build -o), the -run command gets it as $GOWATCH_OUTPUT")
+	fs.StringVar(&cfg.NotifyLevel, "notify-level", "all", "which changes of status to play sounds for: all, or just bad for when something starts failing")
+	fs.DurationVar(&cfg.WatchRefresh, "watch-refresh", 0, "replace the file watcher with a new one and rebuild every `interval`, in case it stops getting events")
+	fs.StringVar(&cfg.HTML, "html", "", "keep an HTML report of the last results in `file`, the e key writes it too")
+	fs.Var((*stringsFlag)(&cfg.Include), "include", "also rebuild when files matching `glob` (relative to the module, ** for any number of directories) change, may be repeated")
+	fs.BoolVar(&cfg.KeepAlive, "keep-alive", false, "report errors that would otherwise stop gowatch, such as failing to watch a directory or the setup command failing, and carry on")
+	fs.BoolVar(&cfg.Warm, "warm", false, "fill the build cache in the background on startup, so the first build after a change is quick, it's stopped by any build")
+	fs.Var((*autoFlag)(&cfg.CI), "ci", "print each result as a plain line like \"TEST: FAIL (3.4s)\" with the output of failures, without colors or clearing, as is done by default when stdout isn't a terminal")
+	fs.BoolVar(&cfg.WatchGen, "watch-generated", false, "rebuild on changes to generated Go files too, those with a \"// Code generated ... DO NOT EDIT.\" comment are ignored by default")
+	fs.StringVar(&cfg.CoverProfile, "coverprofile", "", "save the coverage profile of each passing test run to `file`, the c key opens it with go tool cover -html")
+	fs.BoolVar(&cfg.Syslog, "syslog", false, "also send the results to the system log (journald on Linux), failures at err priority and the rest at info")
+	fs.BoolVar(&cfg.Summary, "summary", false, "on exit, print how many runs there were and how many failed, the longest build and the test that failed most often")
+	fs.StringVar(&cfg.EventsFrom, "events-from", "", "instead of watching the modules, run `cmd` and take each line it prints as the path of a changed file, e.g. from inotifywait over ssh")
+	fs.DurationVar(&cfg.NotifyEvery, "notify-throttle", 0, "notify about each command, with a sound or -notify, at most once per `interval`, changes of status in between are only shown")
+	fs.StringVar(&cfg.File, "file", "", "only watch `file`, of any kind, rebuilding whenever it changes, {} in the commands is replaced with its path")
+	fs.BoolVar(&cfg.ShowChange, "show-change", false, "mark the results whose status has just changed with the one before, e.g. \"Test ✔ (was ✘)\"")
+	fs.IntVar(&cfg.Nice, "nice", 0, "run the build, test and rule commands at nice `level` (up to 19, the lowest priority), on Linux their IO priority is lowered too")
+	fs.BoolVar(&cfg.PrintPlan, "print-plan", false, "print what would be watched, what changes would start a build and the commands that would run as JSON, then exit")
+	fs.StringVar(&cfg.Mod, "mod", "", "add -mod=`mode` (readonly, vendor or mod) to GOFLAGS for the commands, e.g. so they can't change go.mod and go.sum")
+	fs.DurationVar(&cfg.QuickDelay, "quick-delay", 150*time.Millisecond, "with -full-delay, just build once changes have paused for `interval`")
+	fs.DurationVar(&cfg.FullDelay, "full-delay", 0, "only run the tests, rules and the rest once changes have stopped for `interval`, the build alone runs after -quick-delay")
+	fs.BoolVar(&cfg.KeepOthers, "keep-unaffected", false, "with -per-package, leave the tests of the packages a change doesn't affect running instead of restarting them")
+	fs.BoolVar(&cfg.GitScope, "git-scope", false, "only build and test the packages with changes not yet committed in git, as listed by git diff, new files included")
+	fs.StringVar(&cfg.Celebrate, "celebrate-cmd", "", "run `command` in the background whenever everything goes from failing to passing")
+	fs.Var((*mainFlag)(&cfg.MainOnly), "main-only", "build just the main package instead of ./..., the one in the module's directory or the `path` given with -main-only=path")
+	fs.BoolVar(&cfg.ShowGo, "show-go", false, "show the version of go the commands run with below the results, as go version reports it")
+	fs.StringVar(&cfg.OnChange, "on-change-cmd", "", "run `command` with the path of every file event, before anything is filtered out, whether it starts a build or not")
+	fs.BoolVar(&cfg.TAP, "tap", false, "print the test results in TAP format instead of showing the results, running go test with -json")
+	fs.IntVar(&cfg.MaxProcs, "gomaxprocs", 0, "cap the commands at `n` cores, setting GOMAXPROCS for them and go build and go test -p, 0 for no limit")
+	fs.BoolVar(&cfg.Observe, "observe", false, "build nothing, only print each change and what it would start or why it's ignored, to check the options")
+	fs.BoolVar(&cfg.Tidy, "tidy", false, "check with go mod tidy -diff (go 1.23 or later) on each build that go.mod and go.sum are tidy, warning about the changes it would make")
+	fs.BoolVar(&cfg.ShowKilled, "show-killed", false, "show the output a command had printed when it was killed to start it again, below the results")
+	fs.StringVar(&cfg.JUnit, "junit", "", "write the test results to `file` as JUnit XML after each run, running go test with -json")
+	fs.BoolVar(&cfg.Restore, "restore", false, "save the results in "+StateFile+" on exit and show them on startup, marked as stale, until the first build finishes")
+	fs.DurationVar(&cfg.Settle, "settle", 0, "wait this long after a change before reading and building the file, for writes that land after the event, e.g. 50ms")
+	fs.BoolVar(&cfg.Workspace, "workspace", false, "watch and build every module the go.work file uses, each in its own block like -module")
+	fs.BoolVar(&cfg.Columns, "columns", false, "show the build and test results side by side, each in half the width, on terminals at least 100 columns wide")
+	fs.StringVar(&cfg.Go, "go", "go", "run the go `binary` at this path in place of the one on $PATH, for the commands that start with go and gowatch's own")
+	fs.BoolVar(&cfg.CollapsePass, "collapse-passing", false, "show just the ok line of each package whose tests passed, with the output of those that failed in full")
+	fs.BoolVar(&cfg.Blame, "blame", false, "when a command that passed fails, show the files whose change it broke after above it")
+	fs.IntVar(&cfg.Tail, "tail", 0, "show the last `n` lines the -run command has printed below the results, as it prints them")
+	fs.BoolVar(&cfg.Race, "race", false, "run the tests with the race detector (go test -race), any data race it finds fails them and is shown at the top")
+	fs.Var((*stringsFlag)(&cfg.SkipTests), "skip-tests", "leave the package `pkg` (relative to the module, e.g. ./slow or ./integration/...) out of the tests while still building it, may be repeated, the x key tests them once")
+	fs.StringVar(&cfg.EditorCmd, "editor-cmd", "", "the `command` the o key opens the first error with, {file}, {line} and {col} are filled in, by default $EDITOR +{line} {file} or what the editor takes")
+	fs.BoolVar(&cfg.Rollup, "rollup", false, "with several modules, show one line on top that's green only when every module passes, and just the name of those that pass below it")
+	fs.BoolVar(&cfg.Notify, "notify", false, "also show a desktop notification when the status changes (with notify-send or osascript), saying what failed first")
+	fs.StringVar(&cfg.Since, "since", "", "only build and test the packages changed since the branch left the git `ref`, e.g. main, in its commits or not yet committed or tracked, and those edited since")
+	fs.StringVar(&cfg.Markdown, "markdown", "", "keep the status of every command in `file` as a markdown table, with the output of those that failed, for markdown previews")
+	fs.BoolVar(&cfg.ProblemsOnly, "problems-only", false, "only show the commands that failed or warned, hiding the rest until the f key shows them")
+	fs.StringVar(&cfg.ConfigFile, "config", ConfigFile, "read options from the YAML `file`, flags on the command line take precedence")
+}
+
+func main() {
+	cfg, err := loadConfig(flag.CommandLine, os.Args[1:])
+	if cfg.Completion != "" {
+		// The flags are all it needs, even if the config file can't be read.
+		err := printCompletion(cfg.Completion, flag.CommandLine, os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if cfg.healthcheck {
+		err = checkHealth(cfg.HealthFile, cfg.HealthAge)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "unhealthy:", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	err := cmd.Run()
+	err = Main(cfg, os.Stdout, os.Stderr)
 	if err != nil {
-		fmt.Fprintln(eout, err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// clearFailed is set once the clear command has failed, the escape sequence is used from then on.
+var clearFailed bool
+
+func clear(out io.Writer) {
+	if !clearFailed {
+		cmd := exec.Command("clear")
+		cmd.Stdout = out
+
+		err := cmd.Run()
+		if err == nil {
+			return
+		}
+		logger.Info("clear failed, using the ANSI escape sequence instead", "err", err)
+		clearFailed = true
 	}
+	fmt.Fprint(out, "\033[2J\033[H")
 }
 
 // Builder contains a running building process.
 type Builder struct {
 	buildCmd ReusableCommand
 	testCmd  ReusableCommand
+	modCmd   ReusableCommand
+	rerunCmd ReusableCommand
+	vetCmd   ReusableCommand
+
+	// focus is the -run pattern the t key added to the test command, if any.
+	focus string
+
+	// exampleCmd runs the examples alongside the tests, when asked to.
+	exampleCmd ReusableCommand
+
+	// warmCmd fills the build cache with -warm, nothing is shown for it.
+	warmCmd ReusableCommand
+
+	// tidyCmd checks go.mod and go.sum are tidy alongside each build with -tidy.
+	tidyCmd ReusableCommand
+
+	// PerPackage tests each package with a command of its own, pkgCmds by package.
+	// testing are the packages still being tested, tested those of the last run.
+	// KeepOthers only restarts the packages of a run, leaving the others testing.
+	PerPackage bool
+	KeepOthers bool
+	// SkipTests are the packages left out of the tests, which are still built.
+	// listed are the packages go list found for each set of patterns tested, by
+	// the patterns, until go.mod changes or a directory is added.
+	SkipTests []string
+	listed    map[string][]string
+	// Lists gets the packages listed in the background for the tests, to give
+	// back to PackagesListed, they're listed in the foreground without it.
+	// listing counts the tests started, to drop those superseded.
+	Lists   chan<- packageList
+	listing int
+	pkgCmds map[string]*ReusableCommand
+	pkgJobs chan struct{}
+	testing map[string]bool
+	tested  map[string]bool
+
+	// runCmd is restarted after each passing build, when there is one.
+	runCmd ReusableCommand
+
+	// targetCmds build for the other -target platforms alongside the build.
+	targetCmds []*ReusableCommand
+
+	// rules and the commands running them, in the same order.
+	rules    []Rule
+	ruleCmds []*ReusableCommand
 
-	buildOut io.Reader
-	testOut  io.Reader
+	// binDir is where the build writes its binaries when their size is being tracked,
+	// the OutputPath if there is one.
+	binDir string
+	// OutputPath is where the build writes its binary with -output.
+	OutputPath string
+	// CoverProfile is where the coverage profile of the last passing tests is
+	// kept, the tests write it to coverTemp until they've passed.
+	CoverProfile string
+	coverTemp    string
+
+	// BuildFirst holds the tests back whenever the last build didn't pass.
+	BuildFirst   bool
+	lastBuild    Status
+	heldTests    []string
+	heldExamples []string
+
+	// Serial holds the tests back while the build runs, with FailFast they're
+	// skipped if it fails.
+	Serial   bool
+	FailFast bool
+	building bool
+	// quick is set while the build runs on its own ahead of the rest for
+	// -full-delay, vet and the run command wait for the full build.
+	quick bool
+
+	// Strict vets whatever was built, the build only passes if vet does too.
+	Strict    bool
+	builtArgs []string
+	// RerunFails first runs just the tests that failed last time, the full
+	// suite is run to confirm once they pass.
+	RerunFails bool
+	failed     []TestFailure
+	confirm    []string
+
+	// vetScope limits vet to these packages for -incremental, nil for everything built.
+	vetScope []string
 }
 
-// NewBuilder make a new builder.
-func NewBuilder() Builder {
-	builder := Builder{}
+// NewBuilder make a new builder running in dir, the results of all its commands are sent to output.
+// Its commands each take a slot in jobs while they run, a nil jobs doesn't limit them.
+func NewBuilder(cfg Config, dir string, output chan CommandResult, jobs chan struct{}) (*Builder, error) {
+	if cfg.File != "" {
+		cfg.BuildCmd = withFile(cfg.BuildCmd, cfg.File)
+		cfg.TestCmd = withFile(cfg.TestCmd, cfg.File)
+		cfg.RunCmd = withFile(cfg.RunCmd, cfg.File)
+		rules := make([]Rule, len(cfg.Rules))
+		for i, rule := range cfg.Rules {
+			rule.Run = withFile(rule.Run, cfg.File)
+			rules[i] = rule
+		}
+		cfg.Rules = rules
+	}
+
+	builder := &Builder{
+		BuildFirst: cfg.BuildFirst,
+		Strict:     cfg.Strict,
+		RerunFails: cfg.RerunFails,
+		Serial:     cfg.Serial || cfg.FailFast,
+		FailFast:   cfg.FailFast,
+		PerPackage: cfg.PerPackage,
+		KeepOthers: cfg.KeepOthers,
+		SkipTests:  cfg.SkipTests,
+		listed:     map[string][]string{},
+		pkgCmds:    map[string]*ReusableCommand{},
+		pkgJobs:    newPackageJobs(jobs),
+		testing:    map[string]bool{},
+	}
 
 	builder.buildCmd = ReusableCommand{
-		Name:   "Build",
-		Args:   []string{"go", "build", "./..."},
-		Output: make(chan CommandResult),
+		Name:        "Build",
+		Args:        commandArgs(cfg.BuildCmd, cfg.Shell),
+		Dir:         dir,
+		Output:      output,
+		BuildOutput: true,
+	}
+
+	if cfg.MainOnly != "" {
+		builder.buildCmd.Args = scopeArgs(builder.buildCmd.Args, []string{cfg.MainOnly})
+	}
+
+	if cfg.BuildVerbose {
+		builder.buildCmd.Args = goFlags(builder.buildCmd.Args, "build", "-x", "-v")
+	}
+
+	if cfg.Output != "" {
+		path, err := filepath.Abs(cfg.Output)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(cfg.Output, "/") || strings.HasSuffix(cfg.Output, string(filepath.Separator)) {
+			// Abs drops it, but it makes go build write into the directory.
+			path += string(filepath.Separator)
+		}
+		builder.buildCmd.Args = goFlags(builder.buildCmd.Args, "build", "-o", path)
+		builder.OutputPath = path
+		if cfg.Size {
+			builder.binDir = path
+		}
+	} else if cfg.Size {
+		binDir, err := os.MkdirTemp("", "gowatch-")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+		} else {
+			builder.binDir = binDir
+			builder.buildCmd.Args = withOutputDir(builder.buildCmd.Args, binDir)
+		}
 	}
 
+	var testArgs []string
+	if cfg.TestCmd != "" {
+		testArgs = commandWithArgs(cfg.TestCmd, cfg.Shell, cfg.TestArgs)
+	}
 	builder.testCmd = ReusableCommand{
 		Name:   "Test",
-		Args:   []string{"go", "test", "-v", "./..."},
-		Output: make(chan CommandResult),
+		Args:   testArgs,
+		Dir:    dir,
+		Output: output,
+	}
+	if cfg.Shuffle != "" {
+		builder.testCmd.Args = goFlags(builder.testCmd.Args, "test", "-shuffle="+cfg.Shuffle)
+	}
+	if cfg.NoCache {
+		builder.testCmd.Args = goFlags(builder.testCmd.Args, "test", "-count=1")
+	}
+	if cfg.Race {
+		builder.testCmd.Args = goFlags(builder.testCmd.Args, "test", "-race")
+	}
+	if cfg.TAP || cfg.JUnit != "" {
+		builder.testCmd.Args = goFlags(builder.testCmd.Args, "test", "-json")
+	}
+
+	builder.modCmd = ReusableCommand{
+		Name:   "Mod",
+		Args:   []string{"go", "mod", "download"},
+		Dir:    dir,
+		Output: output,
+	}
+
+	builder.vetCmd = ReusableCommand{
+		Name:        "Vet",
+		Args:        []string{"go", "vet", "./..."},
+		Dir:         dir,
+		Output:      output,
+		BuildOutput: true,
+	}
+
+	builder.exampleCmd = ReusableCommand{
+		Name:   "Examples",
+		Dir:    dir,
+		Output: output,
+	}
+	if cfg.Examples {
+		builder.exampleCmd.Args = []string{"go", "test", "-run", "^Example", "./..."}
+		if cfg.NoCache {
+			builder.exampleCmd.Args = goFlags(builder.exampleCmd.Args, "test", "-count=1")
+		}
+	}
+
+	// Nothing reads its result, it's only ever started once and "command finished" logs it.
+	builder.warmCmd = ReusableCommand{
+		Name:   "Warm",
+		Args:   []string{"go", "build", "-o", os.DevNull, "./..."},
+		Dir:    dir,
+		Output: make(chan CommandResult, 1),
+		Prefix: strings.Fields(cfg.ExecPrefix),
+	}
+	if nice, err := exec.LookPath("nice"); err == nil {
+		// At the lowest priority so it doesn't get in the way of anything else.
+		builder.warmCmd.Prefix = append([]string{nice, "-n", "19"}, builder.warmCmd.Prefix...)
+	}
+
+	if cfg.Tidy {
+		builder.tidyCmd = ReusableCommand{
+			Name:     "Tidy",
+			Args:     []string{"go", "mod", "tidy", "-diff"},
+			Dir:      dir,
+			Output:   output,
+			WarnDiff: true,
+		}
+	}
+
+	// Only ever started with the args for the failure being rerun.
+	builder.rerunCmd = ReusableCommand{
+		Name:   "Rerun",
+		Args:   builder.testCmd.Args,
+		Dir:    dir,
+		Output: output,
+	}
+
+	if cfg.CoverProfile != "" {
+		path, err := filepath.Abs(cfg.CoverProfile)
+		if err != nil {
+			return nil, err
+		}
+		temp, err := os.CreateTemp("", "gowatch-*.cover")
+		if err != nil {
+			return nil, err
+		}
+		temp.Close()
+		builder.CoverProfile = path
+		builder.coverTemp = temp.Name()
+		// Only the full runs, not reruns of a failure.
+		builder.testCmd.Args = goFlags(builder.testCmd.Args, "test", "-coverprofile="+builder.coverTemp)
+	}
+
+	for _, target := range cfg.Targets {
+		goos, goarch, found := strings.Cut(target, "/")
+		if !found || goos == "" || goarch == "" {
+			return nil, fmt.Errorf("invalid -target %q, must be os/arch like linux/amd64", target)
+		}
+		args := commandArgs(cfg.BuildCmd, cfg.Shell)
+		if cfg.MainOnly != "" {
+			args = scopeArgs(args, []string{cfg.MainOnly})
+		}
+		builder.targetCmds = append(builder.targetCmds, &ReusableCommand{
+			Name:        "Build " + target,
+			Args:        args,
+			Dir:         dir,
+			Output:      output,
+			Env:         []string{"GOOS=" + goos, "GOARCH=" + goarch},
+			BuildOutput: true,
+		})
+	}
+
+	if cfg.RunCmd != "" {
+		builder.runCmd = ReusableCommand{
+			Name:   "Run",
+			Args:   commandArgs(cfg.RunCmd, cfg.Shell),
+			Dir:    dir,
+			Output: output,
+			Prefix: strings.Fields(cfg.ExecPrefix),
+		}
+		if builder.OutputPath != "" {
+			builder.runCmd.Env = []string{"GOWATCH_OUTPUT=" + builder.OutputPath}
+		}
+		if cfg.Tail > 0 {
+			builder.runCmd.Tail = newLogTail(cfg.Tail)
+		}
+		err := builder.runCmd.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, rule := range cfg.Rules {
+		builder.rules = append(builder.rules, rule)
+		builder.ruleCmds = append(builder.ruleCmds, &ReusableCommand{
+			Name:   rule.Run,
+			Args:   commandArgs(rule.Run, cfg.Shell),
+			Dir:    dir,
+			Output: output,
+		})
+	}
+
+	cmds := []*ReusableCommand{&builder.buildCmd, &builder.testCmd, &builder.modCmd, &builder.vetCmd, &builder.rerunCmd, &builder.exampleCmd, &builder.tidyCmd}
+	cmds = append(cmds, builder.targetCmds...)
+	for _, mcmd := range append(cmds, builder.ruleCmds...) {
+		mcmd.jobs = jobs
+		mcmd.Prefix = strings.Fields(cfg.ExecPrefix)
+		mcmd.Nice = cfg.Nice
+		mcmd.ShowKilled = cfg.ShowKilled
+		policy := cfg.Commands[strings.ToLower(mcmd.Name)]
+		mcmd.Timeout, mcmd.Retries = policy.Timeout, policy.Retries
+	}
+	err := useNeeds(cfg.Commands, append(cmds, builder.ruleCmds...))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PerPackage {
+		for _, mcmd := range append(cmds, builder.ruleCmds...) {
+			for _, need := range mcmd.Needs {
+				if need == "test" {
+					return nil, fmt.Errorf("commands: %s can't need test with -per-package, each package is tested on its own", strings.ToLower(mcmd.Name))
+				}
+			}
+		}
+	}
+
+	if cfg.Mod != "" {
+		switch cfg.Mod {
+		case "readonly", "vendor", "mod":
+		default:
+			return nil, fmt.Errorf("invalid -mod %q, must be readonly, vendor or mod", cfg.Mod)
+		}
+		// Added to any GOFLAGS gowatch was given, the last -mod wins.
+		goflags := "GOFLAGS=" + strings.TrimSpace(os.Getenv("GOFLAGS")+" -mod="+cfg.Mod)
+		for _, mcmd := range append(append(cmds, builder.ruleCmds...), &builder.runCmd, &builder.warmCmd) {
+			mcmd.Env = append(mcmd.Env, goflags)
+		}
+	}
+
+	if cfg.MaxProcs < 0 {
+		return nil, fmt.Errorf("invalid -gomaxprocs %d, must be 0 or more", cfg.MaxProcs)
+	}
+	if cfg.MaxProcs > 0 {
+		procs := strconv.Itoa(cfg.MaxProcs)
+		for _, mcmd := range append(append(cmds, builder.ruleCmds...), &builder.runCmd, &builder.warmCmd) {
+			mcmd.Env = append(mcmd.Env, "GOMAXPROCS="+procs)
+		}
+		// The go tool also builds that many packages at once, and go test tests them.
+		for _, mcmd := range append([]*ReusableCommand{&builder.buildCmd, &builder.warmCmd}, builder.targetCmds...) {
+			mcmd.Args = goFlags(mcmd.Args, "build", "-p="+procs)
+		}
+		for _, mcmd := range []*ReusableCommand{&builder.testCmd, &builder.exampleCmd} {
+			mcmd.Args = goFlags(mcmd.Args, "test", "-p="+procs)
+		}
 	}
 
-	return builder
+	validate := []*ReusableCommand{&builder.buildCmd}
+	if cfg.TestCmd != "" {
+		// An empty test command skips the tests.
+		validate = append(validate, &builder.testCmd)
+	}
+	if builder.HasExamples() {
+		validate = append(validate, &builder.exampleCmd)
+	}
+	for _, mcmd := range append(validate, builder.ruleCmds...) {
+		err := mcmd.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return builder, nil
 }
 
 // Start the build.
 func (builder *Builder) Start() {
 	builder.Kill()
+	builder.quick = false
+	builder.builtArgs = builder.buildCmd.Args
+	builder.building = true
 	builder.buildCmd.Start()
-	builder.testCmd.Start()
+	builder.startTidy()
+	for _, mcmd := range builder.targetCmds {
+		mcmd.Start()
+	}
+	builder.startTests(builder.testCmd.Args)
+	builder.startExamples(builder.exampleCmd.Args)
+}
+
+// StartBuild starts just the build (and the -target builds) of pkgs, or every
+// package when nil, ahead of the rest for -full-delay.
+func (builder *Builder) StartBuild(pkgs []string) {
+	builder.Kill()
+	// Tests held back from before are for code that's changed since.
+	builder.heldTests, builder.heldExamples = nil, nil
+	builder.builtArgs = builder.buildCmd.Args
+	if pkgs != nil {
+		builder.builtArgs = scopeArgs(builder.buildCmd.Args, pkgs)
+	}
+	builder.building = true
+	builder.quick = true
+	builder.buildCmd.StartWith(builder.builtArgs)
+	for _, mcmd := range builder.targetCmds {
+		if pkgs != nil {
+			mcmd.StartWith(scopeArgs(mcmd.Args, pkgs))
+		} else {
+			mcmd.Start()
+		}
+	}
+}
+
+// StartRest starts everything but the build, once the quick build of pkgs for
+// -full-delay has passed: tidy and the tests, vet and the run command follow.
+func (builder *Builder) StartRest(pkgs []string) {
+	builder.quick = false
+	builder.startTidy()
+	tests, examples := builder.testCmd.Args, builder.exampleCmd.Args
+	if pkgs != nil {
+		tests, examples = scopeArgs(tests, pkgs), scopeArgs(examples, pkgs)
+	}
+	builder.startTests(tests)
+	builder.startExamples(examples)
+}
+
+// QuickPassed reports whether the last build was a quick one for -full-delay, and passed.
+func (builder *Builder) QuickPassed() bool {
+	return builder.quick && !builder.building && builder.lastBuild == StatusOk
+}
+
+// StartFiltered starts only the test command when onlyTests is set, otherwise the full build.
+func (builder *Builder) StartFiltered(onlyTests bool) {
+	if !onlyTests {
+		builder.Start()
+		return
+	}
+	builder.startTests(builder.testCmd.Args)
+	builder.startExamples(builder.exampleCmd.Args)
+}
+
+// StartScoped is StartFiltered with the commands limited to pkgs.
+func (builder *Builder) StartScoped(onlyTests bool, pkgs []string) {
+	if !onlyTests {
+		if builder.KeepOthers {
+			builder.killCommands()
+		} else {
+			builder.Kill()
+		}
+		builder.quick = false
+		builder.builtArgs = scopeArgs(builder.buildCmd.Args, pkgs)
+		builder.building = true
+		builder.buildCmd.StartWith(builder.builtArgs)
+		for _, mcmd := range builder.targetCmds {
+			mcmd.StartWith(scopeArgs(mcmd.Args, pkgs))
+		}
+		builder.startTidy()
+	}
+	builder.startTests(scopeArgs(builder.testCmd.Args, pkgs))
+	builder.startExamples(scopeArgs(builder.exampleCmd.Args, pkgs))
+}
+
+// startTidy runs go mod tidy -diff alongside the build with -tidy.
+func (builder *Builder) startTidy() {
+	if builder.HasTidy() {
+		builder.tidyCmd.Start()
+	}
+}
+
+// HasTidy reports whether go.mod and go.sum are checked with each build.
+func (builder *Builder) HasTidy() bool {
+	return len(builder.tidyCmd.Args) > 0
+}
+
+// startTests runs the tests with args, unless they're being held back until the build passes.
+func (builder *Builder) startTests(args []string) {
+	if !builder.HasTests() {
+		return
+	}
+	builder.confirm = nil
+	if builder.RerunFails {
+		if quick := failuresArgs(args, builder.failed); quick != nil {
+			builder.confirm = args
+			args = quick
+		}
+	}
+	if builder.holdTests() {
+		builder.testCmd.Hold()
+		builder.killPackages()
+		builder.heldTests = args
+		return
+	}
+	builder.runTests(args)
+}
+
+// startExamples runs the examples with args, held back along with the tests.
+func (builder *Builder) startExamples(args []string) {
+	if !builder.HasExamples() {
+		return
+	}
+	if builder.holdTests() {
+		builder.exampleCmd.Hold()
+		builder.heldExamples = args
+		return
+	}
+	builder.exampleCmd.StartWith(args)
+}
+
+// holdTests reports whether tests started now have to wait for the build.
+func (builder *Builder) holdTests() bool {
+	return (builder.BuildFirst && builder.lastBuild != StatusOk) || (builder.Serial && builder.building)
+}
+
+// WaitingForBuild reports whether tests are held back with no build running
+// to start them, until one passes with BuildFirst.
+func (builder *Builder) WaitingForBuild() bool {
+	return !builder.building && (builder.heldTests != nil || builder.heldExamples != nil)
+}
+
+// HasTests reports whether there's a test command, an empty one skips the tests.
+func (builder *Builder) HasTests() bool {
+	return len(builder.testCmd.Args) > 0
+}
+
+// HasExamples reports whether the examples are run on their own.
+func (builder *Builder) HasExamples() bool {
+	return len(builder.exampleCmd.Args) > 0
+}
+
+// BuildFinished records the build's status and starts any tests that were waiting for it.
+// It returns true if the waiting tests don't run now, skipped because the build
+// failed with FailFast or, with BuildFirst, held until a build passes.
+func (builder *Builder) BuildFinished(status Status) bool {
+	builder.lastBuild = status
+	builder.building = false
+	tests, examples := builder.heldTests, builder.heldExamples
+	if tests == nil && examples == nil {
+		return false
+	}
+	if status != StatusOk && builder.FailFast {
+		builder.heldTests, builder.heldExamples = nil, nil
+		// They won't run, nothing needing them should wait for them.
+		builder.testCmd.Kill()
+		builder.exampleCmd.Kill()
+		return true
+	}
+	if status != StatusOk && builder.BuildFirst {
+		return true
+	}
+	builder.heldTests, builder.heldExamples = nil, nil
+	if tests != nil {
+		builder.runTests(tests)
+	}
+	if examples != nil {
+		builder.exampleCmd.StartWith(examples)
+	}
+	return false
+}
+
+// ToggleVerbose adds -v to the test command, or removes it if it's there. It
+// returns false if the test command isn't go test.
+func (builder *Builder) ToggleVerbose() bool {
+	args := builder.testCmd.Args
+	if len(args) < 2 || args[0] != "go" || args[1] != "test" {
+		return false
+	}
+	if builder.Verbose() {
+		var quiet []string
+		for _, arg := range args {
+			if arg != "-v" && arg != "-v=true" {
+				quiet = append(quiet, arg)
+			}
+		}
+		builder.testCmd.Args = quiet
+	} else {
+		builder.testCmd.Args = goFlags(args, "test", "-v")
+	}
+	return true
+}
+
+// FocusTests adds -run pattern to the test command in place of the last one it
+// added, an empty pattern runs every test again. It returns false if the test
+// command isn't go test.
+func (builder *Builder) FocusTests(pattern string) bool {
+	args := builder.testCmd.Args
+	if len(args) < 2 || args[0] != "go" || args[1] != "test" {
+		return false
+	}
+	if builder.focus != "" {
+		for i := 2; i+1 < len(args); i++ {
+			if args[i] == "-run" && args[i+1] == builder.focus {
+				args = append(args[:i:i], args[i+2:]...)
+				break
+			}
+		}
+	}
+	builder.focus = pattern
+	if pattern != "" {
+		args = goFlags(args, "test", "-run", pattern)
+	}
+	builder.testCmd.Args = args
+	return true
+}
+
+// Verbose reports whether the tests run with -v.
+func (builder *Builder) Verbose() bool {
+	for _, arg := range builder.testCmd.Args {
+		if arg == "-v" || arg == "-v=true" {
+			return true
+		}
+	}
+	return false
+}
+
+// TestsFinished remembers the failures of a test run for RerunFails. It returns true if
+// the run was of just the earlier failures and passed, the full suite is started instead.
+func (builder *Builder) TestsFinished(cr CommandResult) bool {
+	if !builder.RerunFails {
+		return false
+	}
+	builder.failed = nil
+	if cr.Status == StatusBad {
+		builder.failed = cr.Tests.Failures
+	}
+
+	confirm := builder.confirm
+	builder.confirm = nil
+	if cr.Status != StatusOk || confirm == nil {
+		return false
+	}
+	builder.runTests(confirm)
+	return true
+}
+
+// scopeArgs replaces every "./..." in args with pkgs.
+func scopeArgs(args []string, pkgs []string) []string {
+	var scoped []string
+	for _, arg := range args {
+		if arg == "./..." {
+			scoped = append(scoped, pkgs...)
+			continue
+		}
+		scoped = append(scoped, arg)
+	}
+	return scoped
+}
+
+// StartRules starts the commands of the rules matching files, returning their indexes.
+func (builder *Builder) StartRules(files []string) []int {
+	var started []int
+	for i, rule := range builder.rules {
+		if !rule.Before && rule.matches(files) {
+			builder.ruleCmds[i].Start()
+			started = append(started, i)
+		}
+	}
+	return started
+}
+
+// StartGenerators starts the commands of the rules run before the build that match files,
+// returning their indexes.
+func (builder *Builder) StartGenerators(files []string) []int {
+	var started []int
+	for i, rule := range builder.rules {
+		if rule.Before && rule.matches(files) {
+			builder.ruleCmds[i].Start()
+			started = append(started, i)
+		}
+	}
+	return started
+}
+
+// Generates reports whether any of files start a rule run before the build.
+func (builder *Builder) Generates(files []string) bool {
+	for _, rule := range builder.rules {
+		if rule.Before && rule.matches(files) {
+			return true
+		}
+	}
+	return false
+}
+
+// targetIndex returns the index of the -target build called name, or -1.
+func (builder *Builder) targetIndex(name string) int {
+	for i, mcmd := range builder.targetCmds {
+		if mcmd.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// ruleIndex returns the index of the rule whose command is called name, or -1.
+func (builder *Builder) ruleIndex(name string) int {
+	for i, mcmd := range builder.ruleCmds {
+		if mcmd.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// StartModules downloads the module dependencies, the build should be started once it succeeds.
+func (builder *Builder) StartModules() {
+	builder.Kill()
+	builder.modCmd.Start()
+}
+
+// StartVet vets the packages just built in strict mode, returning false if there's nothing to vet.
+func (builder *Builder) StartVet() bool {
+	if !builder.Strict || builder.quick {
+		return false
+	}
+	args := vetArgs(builder.builtArgs)
+	if args == nil {
+		return false
+	}
+	if builder.vetScope != nil {
+		args = append(args[:2:2], builder.vetScope...)
+	}
+	builder.vetCmd.StartWith(args)
+	return true
+}
+
+// vetArgs makes a go vet command for the packages of a go build command, or nil if it isn't go build.
+func vetArgs(buildArgs []string) []string {
+	if len(buildArgs) < 2 || buildArgs[0] != "go" || buildArgs[1] != "build" {
+		return nil
+	}
+
+	args := []string{"go", "vet"}
+	for i := 2; i < len(buildArgs); i++ {
+		arg := buildArgs[i]
+		if !strings.HasPrefix(arg, "-") {
+			args = append(args, arg)
+		} else if takesValue(arg) {
+			// Its value isn't a package either.
+			i++
+		}
+	}
+	return args
+}
+
+// Rerun runs only the failed test f, it returns false if the test command isn't go test.
+func (builder *Builder) Rerun(f TestFailure) bool {
+	args := rerunArgs(builder.testCmd.Args, f)
+	if args == nil {
+		return false
+	}
+	builder.rerunCmd.StartWith(args)
+	return true
 }
 
 // Kill the build.
 func (builder *Builder) Kill() {
+	builder.killCommands()
+	builder.killPackages()
+}
+
+// killCommands kills everything Kill does but the tests of each package.
+func (builder *Builder) killCommands() {
+	builder.warmCmd.Kill()
+	builder.tidyCmd.Kill()
+	builder.vetCmd.Kill()
+	builder.rerunCmd.Kill()
+	builder.modCmd.Kill()
 	builder.testCmd.Kill()
+	builder.exampleCmd.Kill()
 	builder.buildCmd.Kill()
+	for _, mcmd := range builder.targetCmds {
+		mcmd.Kill()
+	}
+}
+
+// Stop kills everything, including the run and rule commands that restarting the build leaves running.
+func (builder *Builder) Stop() {
+	builder.Kill()
+	builder.runCmd.Kill()
+	for _, mcmd := range builder.ruleCmds {
+		mcmd.Kill()
+	}
+}
+
+// Warm starts filling the build cache, it's stopped by the next build.
+func (builder *Builder) Warm() {
+	logger.Debug("warming the build cache", "dir", builder.buildCmd.Dir)
+	builder.warmCmd.Start()
+}
+
+// StartRun restarts the run command, it returns false if there isn't one.
+func (builder *Builder) StartRun() bool {
+	if len(builder.runCmd.Args) == 0 || builder.quick {
+		return false
+	}
+	builder.runCmd.Start()
+	return true
 }
 
 // ReusableCommand stores a command to execute, if it is started again while the last execution is still running it will kill it silently.
 type ReusableCommand struct {
-	cmd    *exec.Cmd
-	lock   sync.Mutex
+	cmd  *exec.Cmd
+	lock sync.Mutex
+	// jobs limits how many commands run at once, a slot is taken while running.
+	jobs   chan struct{}
 	Name   string
 	Args   []string
+	Dir    string
 	Output chan (CommandResult)
+
+	// Prefix is run with Args as its arguments, e.g. to run the command in a container.
+	Prefix []string
+	// Env is added to gowatch's own environment for the command.
+	Env []string
+	// Nice is the priority it runs at with -nice, 0 leaves it as gowatch's.
+	Nice int
+	// BuildOutput takes every file:line in the output as an error, for go build
+	// and go vet. Otherwise only those a compile prints count, not a test's logging.
+	BuildOutput bool
+	// WarnDiff makes a failure printing a diff only a warning, for go mod tidy -diff.
+	WarnDiff bool
+	// ShowKilled sends a killed result with the output so far when it's
+	// started again before it finishes, for -show-killed.
+	ShowKilled bool
+	// Timeout kills the command when it runs for longer, failing it, 0 for no limit.
+	Timeout time.Duration
+	// Retries is how many more times a failed command is run before its failure counts.
+	Retries int
+	// Tail gets the output as it's printed as well, nil if it's only needed at the end.
+	Tail *logTail
+	// Needs are the lowercased names of the commands that have to pass before
+	// it runs, needs holds it back until they have.
+	Needs []string
+	needs *needsGate
+}
+
+// CommandPolicy is how long a command may run, how often it's retried when
+// it fails and which commands it waits for, from the commands section of the
+// config file.
+type CommandPolicy struct {
+	Timeout time.Duration `yaml:"timeout"`
+	Retries int           `yaml:"retries"`
+	Needs   []string      `yaml:"needs"`
 }
 
 // Status of CommandResult
@@ -87,74 +1226,411 @@ const (
 	StatusDirty Status = iota
 	StatusOk
 	StatusBad
+	// StatusWarn is a finished command that only found something worth a look,
+	// like the changes go mod tidy would make. It doesn't fail the build.
+	StatusWarn
+	// StatusKilled is a command killed to be started again, only shown with -show-killed.
+	StatusKilled
 )
 
+func (s Status) String() string {
+	switch s {
+	case StatusOk:
+		return "ok"
+	case StatusBad:
+		return "bad"
+	case StatusWarn:
+		return "warn"
+	case StatusKilled:
+		return "killed"
+	}
+	return "dirty"
+}
+
 // CommandResult stores the result of a completed ReusableCommand operation.
 type CommandResult struct {
 	Output string
 	Name   string
+	Dir    string
 	Status Status
+	Errors []BuildError
+
+	// Size of the built binaries and the change since the last build, when tracked.
+	Size      int64
+	SizeDelta int64
+
+	// Resources used by the command.
+	CPU    time.Duration
+	MaxRSS int64
+
+	// Exit describes how a failed command ended, e.g. "exit status 2".
+	Exit string
+
+	Duration time.Duration
+
+	// Tests is what was understood of the output as go test output.
+	Tests TestReport
+
+	// Command is the command line that was run, in the form of a shell command.
+	Command string
+
+	// Hints describe the kinds of go tool errors in the output, like import cycles.
+	Hints []string
+
+	// Was is the status of the command's previous result when it's just changed, with -show-change.
+	Was Status
+
+	// Blame are the changed files it started failing after, with -blame.
+	Blame []string
 }
 
 var ok = color.New(color.Bold, color.FgGreen).SprintFunc()
 var bad = color.New(color.Bold, color.FgRed).SprintFunc()
 var refresh = color.New(color.Bold, color.FgWhite).SprintFunc()
+var warn = color.New(color.Bold, color.FgYellow).SprintFunc()
 var normal = color.New(color.FgWhite, color.Bold).SprintFunc()
 var dim = color.New(color.FgWhite, color.Faint).SprintFunc()
+var cached = color.New(color.FgWhite, color.Faint).SprintFunc()
+
+// statusColor returns the color of status.
+func statusColor(status Status) func(a ...interface{}) string {
+	switch status {
+	case StatusOk:
+		return ok
+	case StatusBad:
+		return bad
+	case StatusWarn:
+		return warn
+	case StatusKilled:
+		return dim
+	}
+	return refresh
+}
+
+// runningMessages replace the earlier output while a command runs, by lowercase command name.
+var runningMessages map[string]string
+
+// setRunningMessages sets runningMessages from the running section of the config file.
+func setRunningMessages(running map[string]string) {
+	runningMessages = map[string]string{}
+	for name, msg := range running {
+		runningMessages[strings.ToLower(name)] = msg
+	}
+}
+
+// commandLabels are the names commands are shown with in place of their own,
+// and commandTags the shorter ones in the status file, both by lowercase command name.
+var commandLabels, commandTags map[string]string
+
+// setLabels sets commandLabels and commandTags from the labels and tags sections of the config file.
+func setLabels(labels, tags map[string]string) {
+	commandLabels = map[string]string{}
+	for name, label := range labels {
+		commandLabels[strings.ToLower(name)] = label
+	}
+	commandTags = map[string]string{}
+	for name, tag := range tags {
+		commandTags[strings.ToLower(name)] = tag
+	}
+}
+
+// Label is the name cr is shown with, its label from the config file if it has one.
+func (cr *CommandResult) Label() string {
+	if label, found := commandLabels[strings.ToLower(cr.Name)]; found {
+		return label
+	}
+	return cr.Name
+}
+
+// commandTag is the command called name in the status file, its tag from the
+// config file or else its name in lowercase.
+func commandTag(name string) string {
+	if tag, found := commandTags[strings.ToLower(name)]; found {
+		return tag
+	}
+	return strings.ToLower(name)
+}
+
+// cmdName colors command names, when nil they take the color of their status.
+var cmdName func(a ...interface{}) string
 
 // StatusIcon maps a Status state to a unicode icon.
 var StatusIcon = map[Status]string{
-	StatusDirty: "⟳",
-	StatusOk:    "✔",
-	StatusBad:   "✘",
+	StatusDirty:  "⟳",
+	StatusOk:     "✔",
+	StatusBad:    "✘",
+	StatusWarn:   "⚠",
+	StatusKilled: "✂",
 }
 
 func (cr *CommandResult) String() string {
-	state := ok
+	state := statusColor(cr.Status)
 	text := normal
-	if cr.Status == StatusBad {
-		state = bad
-	} else if cr.Status == StatusDirty {
-		state = refresh
+	if cr.Status == StatusDirty {
 		text = dim
 	}
 
-	return state(cr.Name+" "+StatusIcon[cr.Status]) + normal(": ") + text(cr.Output)
+	icon, state := statusStyle(cr.Name, cr.Status, state)
+	label := cr.Label()
+	header := state(label+" "+icon) + normal(": ")
+	if cmdName != nil {
+		header = cmdName(label) + " " + state(icon) + normal(": ")
+	}
+	if msg, found := runningMessages[strings.ToLower(cr.Name)]; found && cr.Status == StatusDirty {
+		return header + text(msg)
+	}
+	if cr.Was != StatusDirty && cr.Status != StatusDirty && cr.Was != cr.Status {
+		was, wasColor := statusStyle(cr.Name, cr.Was, statusColor(cr.Was))
+		header += dim("(was ") + wasColor(was) + dim(") ")
+	}
+	if (cr.Status == StatusBad || cr.Status == StatusKilled) && cr.Exit != "" {
+		header += dim("(" + cr.Exit + ") ")
+	}
+	if seeds := cr.Tests.failedSeeds(); cr.Status == StatusBad && len(seeds) > 0 {
+		header += dim("(" + strings.Join(seeds, ", ") + ") ")
+	}
+	if cr.Status == StatusOk && cr.Tests.allCached() {
+		header += cached("(cached) ")
+	}
+	if cr.Size > 0 {
+		size := formatSize(cr.Size)
+		if cr.SizeDelta != 0 {
+			size += " " + formatSizeDelta(cr.SizeDelta)
+		}
+		header += dim("(" + size + ") ")
+	}
+	if cr.CPU > 0 {
+		usage := "cpu " + cr.CPU.Round(time.Millisecond).String()
+		if cr.MaxRSS > 0 {
+			usage += ", rss " + formatSize(cr.MaxRSS)
+		}
+		header += dim("(" + usage + ") ")
+	}
+	if len(cr.Errors) == 0 && len(cr.Tests.Packages) > 0 {
+		return header + markCached(text, cr.Output)
+	}
+	if len(cr.Errors) == 0 {
+		return header + text(cr.Output)
+	}
+
+	// Render parsed errors as a compact list instead of the raw output.
+	lines := []string{header}
+	groups := groupByFile(cr.Errors)
+	if len(groups) == 1 {
+		for _, be := range cr.Errors {
+			lines = append(lines, "  "+text(hyperlink(cr.Dir, be, be.String())))
+		}
+		return strings.Join(lines, "\n")
+	}
+	for _, group := range groups {
+		lines = append(lines, "  "+normal(hyperlink(cr.Dir, group[0], group[0].File)))
+		for _, be := range group {
+			lines = append(lines, "    "+text(hyperlink(cr.Dir, be, be.Position())+": "+be.Message))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Validate checks there is a command and the program it runs can be found.
+func (mcmd *ReusableCommand) Validate() error {
+	if len(mcmd.Args) == 0 || mcmd.Args[0] == "" {
+		return fmt.Errorf("the %s command is empty", strings.ToLower(mcmd.Name))
+	}
+
+	program := mcmd.Args[0]
+	if len(mcmd.Prefix) > 0 {
+		program = mcmd.Prefix[0]
+	}
+	if strings.ContainsRune(program, filepath.Separator) && !filepath.IsAbs(program) {
+		// Relative paths are run from the command's directory.
+		program = filepath.Join(mcmd.Dir, program)
+	}
+	_, err := exec.LookPath(program)
+	if err != nil {
+		return fmt.Errorf("the %s command can't be run: %v", strings.ToLower(mcmd.Name), err)
+	}
+	return nil
 }
 
 // Start begins executing the command.
 func (mcmd *ReusableCommand) Start() {
-	mcmd.Kill()
+	mcmd.StartWith(mcmd.Args)
+}
+
+// StartWith begins executing the command with args in place of Args for this run only.
+func (mcmd *ReusableCommand) StartWith(args []string) {
+	if len(args) == 0 {
+		go func() {
+			mcmd.Output <- CommandResult{Name: mcmd.Name, Dir: mcmd.Dir, Status: StatusBad, Output: "no command to run"}
+		}()
+		return
+	}
+
+	// Not Kill, the commands that need it wait for the new run.
+	mcmd.stop()
+	if mcmd.needs != nil {
+		mcmd.needs.start(mcmd, args)
+		return
+	}
+	mcmd.launch(args)
+}
+
+// launch runs args in the background, sending the result to Output.
+func (mcmd *ReusableCommand) launch(args []string) {
+	// Whatever runs now is superseded, even one launched since it was started.
+	mcmd.killProcess()
+	mcmd.reset(args)
+	logger.Debug("starting command", "name", mcmd.Name, "dir", mcmd.Dir, "args", args)
 
 	mcmd.lock.Lock()
+	cmd := mcmd.cmd
+	mcmd.lock.Unlock()
 	go func() {
-		cmd := mcmd.cmd
+		for attempt := 1; ; attempt++ {
+			cr, finished := mcmd.run(cmd)
+			if !finished {
+				return
+			}
+			if cr.Status != StatusBad || attempt > mcmd.Retries {
+				logger.Info("command finished", "name", cr.Name, "dir", cr.Dir, "status", cr.Status, "attempts", attempt)
+				if mcmd.needs != nil {
+					mcmd.needs.finished(mcmd, cmd, cr.Status)
+				}
+				mcmd.Output <- cr
+				return
+			}
+			logger.Info("retrying the command", "name", cr.Name, "dir", cr.Dir, "attempt", attempt+1)
+			cmd = mcmd.retry(cmd, args)
+			if cmd == nil {
+				// Started again in the meantime.
+				return
+			}
+		}
+	}()
+}
+
+// retry replaces cmd, which has failed, with a new run of args. It returns nil
+// if cmd had already been killed or replaced by a newer run.
+func (mcmd *ReusableCommand) retry(cmd *exec.Cmd, args []string) *exec.Cmd {
+	mcmd.lock.Lock()
+	defer mcmd.lock.Unlock()
+	if mcmd.cmd != cmd {
+		return nil
+	}
+	mcmd.cmd = mcmd.command(args)
+	return mcmd.cmd
+}
+
+// run runs cmd and returns its result. It's unfinished when cmd was killed to
+// start it again, there's nothing to report then.
+func (mcmd *ReusableCommand) run(cmd *exec.Cmd) (CommandResult, bool) {
+	if mcmd.jobs != nil {
+		mcmd.jobs <- struct{}{}
+	}
 
-		var outBuf bytes.Buffer
-		cmd.Stdout = &outBuf
+	var outBuf bytes.Buffer
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &outBuf
+	if mcmd.Tail != nil {
+		mcmd.Tail.reset()
+		out := io.MultiWriter(&outBuf, mcmd.Tail)
+		cmd.Stdout = out
+		cmd.Stderr = out
+	}
 
-		err := cmd.Start()
+	mcmd.lock.Lock()
+	if mcmd.cmd != cmd {
+		// Killed to be restarted while waiting for a job slot.
 		mcmd.lock.Unlock()
+		mcmd.release()
+		return CommandResult{}, false
+	}
+	started := time.Now()
+	err := cmd.Start()
+	if err == nil && mcmd.Nice != 0 {
+		// Straight away, so little of it runs before.
+		if err := setNice(cmd.Process.Pid, mcmd.Nice); err != nil {
+			logger.Debug("setting the priority", "name", mcmd.Name, "err", err)
+		}
+	}
+	mcmd.lock.Unlock()
 
+	var timedOut atomic.Bool
+	if err != nil {
+		// The command never ran, e.g. the runner isn't installed.
+		fmt.Fprintln(&outBuf, err)
+	} else {
+		if mcmd.Timeout > 0 {
+			pid := cmd.Process.Pid
+			timer := time.AfterFunc(mcmd.Timeout, func() {
+				timedOut.Store(true)
+				killGroup(pid)
+			})
+			defer timer.Stop()
+		}
 		err = cmd.Wait()
+	}
+	mcmd.release()
 
-		cr := CommandResult{
-			Output: outBuf.String(),
-			Name:   mcmd.Name,
-			Status: StatusOk,
-		}
+	cr := CommandResult{
+		Output:   outBuf.String(),
+		Name:     mcmd.Name,
+		Dir:      mcmd.Dir,
+		Status:   StatusOk,
+		Duration: time.Since(started),
+		Command:  commandLine(mcmd.Dir, cmd.Env, cmd.Args),
+	}
+	plain, cases, isJSON := parseTestJSON(cr.Output)
+	if isJSON {
+		// Shown and understood the same as go test without -json.
+		cr.Output = plain
+	}
+	if mcmd.BuildOutput {
+		cr.Errors = ParseBuildErrors(cr.Output)
+	} else {
+		cr.Errors = compileErrors(cr.Output)
+	}
+	cr.Hints = ErrorHints(cr.Output)
+	cr.Tests = ParseTestOutput(cr.Output)
+	cr.Tests.Cases = cases
+	if cr.Tests.Races > 0 && err == nil {
+		// go test fails on a race by itself, a -race binary run by a rule only reports it.
+		cr.Status = StatusBad
+		cr.Exit = "data race"
+	}
+	if ps := cmd.ProcessState; ps != nil {
+		cr.CPU = ps.UserTime() + ps.SystemTime()
+		cr.MaxRSS = maxRSS(ps)
+	}
 
-		if err != nil {
-			// Don't output anything is the command was killed.
-			if WasKilled(err) {
-				return
+	if err != nil {
+		// Don't output anything is the command was killed to be restarted,
+		// being killed by anything else is a failure like any other.
+		if WasKilled(err) && mcmd.superseded(cmd) {
+			logger.Debug("command was killed", "name", mcmd.Name, "dir", mcmd.Dir)
+			if mcmd.ShowKilled {
+				cr.Status = StatusKilled
+				cr.Exit = "killed to start again"
+				mcmd.Output <- cr
 			}
+			return cr, false
+		}
 
-			cr.Status = StatusBad
+		cr.Status = StatusBad
+		cr.Exit = err.Error()
+		if timedOut.Load() {
+			cr.Exit = "timed out after " + mcmd.Timeout.String()
+		}
+		if mcmd.WarnDiff && isDiff(cr.Output) {
+			cr.Status = StatusWarn
 		}
+	}
+	return cr, true
+}
 
-		mcmd.Output <- cr
-	}()
+// isDiff reports whether output is a diff, like go mod tidy -diff prints.
+func isDiff(output string) bool {
+	return strings.HasPrefix(output, "diff ") || strings.HasPrefix(output, "--- ") || strings.Contains(output, "\n--- ")
 }
 
 // WasKilled will check an error as returned by Command.Wait and return true if it was killed.
@@ -175,73 +1651,299 @@ func WasKilled(err error) bool {
 
 // Kill the running command.
 func (mcmd *ReusableCommand) Kill() {
-	{
-		mcmd.lock.Lock()
-		if mcmd.cmd != nil && mcmd.cmd.Process != nil {
-			mcmd.cmd.Process.Kill()
-		}
-		mcmd.lock.Unlock()
+	mcmd.killProcess()
+	if mcmd.needs != nil {
+		mcmd.needs.drop(mcmd)
+	}
+	mcmd.reset(mcmd.Args)
+}
+
+// Hold kills the running command until it's started again, the commands that
+// need it wait for that run rather than being given up on.
+func (mcmd *ReusableCommand) Hold() {
+	mcmd.stop()
+	if mcmd.needs != nil {
+		mcmd.needs.hold(mcmd)
+	}
+}
+
+// stop kills the running command, leaving the commands that need it be.
+func (mcmd *ReusableCommand) stop() {
+	mcmd.killProcess()
+	mcmd.reset(mcmd.Args)
+}
+
+// killProcess kills the process of the command, if it's running.
+func (mcmd *ReusableCommand) killProcess() {
+	mcmd.lock.Lock()
+	defer mcmd.lock.Unlock()
+	if mcmd.cmd != nil && mcmd.cmd.Process != nil {
+		// Kill the whole process group so commands run through a shell go too.
+		logger.Debug("killing command", "name", mcmd.Name, "dir", mcmd.Dir, "pid", mcmd.cmd.Process.Pid)
+		killGroup(mcmd.cmd.Process.Pid)
+	}
+}
+
+// release gives back the job slot taken to run the command.
+func (mcmd *ReusableCommand) release() {
+	if mcmd.jobs != nil {
+		<-mcmd.jobs
 	}
-	mcmd.reset()
 }
 
-func (mcmd *ReusableCommand) reset() {
+// superseded reports whether cmd has since been killed or replaced by a newer run.
+func (mcmd *ReusableCommand) superseded(cmd *exec.Cmd) bool {
 	mcmd.lock.Lock()
 	defer mcmd.lock.Unlock()
-	mcmd.cmd = exec.Command(mcmd.Args[0], mcmd.Args[1:]...)
+	return mcmd.cmd != cmd
 }
 
-func display(out io.Writer, bRes, tRes CommandResult) {
-	clear(out)
-	fmt.Fprintln(out, bRes.String())
-	fmt.Fprintln(out, tRes.String())
+func (mcmd *ReusableCommand) reset(args []string) {
+	mcmd.lock.Lock()
+	defer mcmd.lock.Unlock()
+	if len(args) == 0 {
+		mcmd.cmd = nil
+		return
+	}
+	mcmd.cmd = mcmd.command(args)
 }
 
-// Main function
-func Main(out io.Writer, eout io.Writer) error {
+// command makes the command running args, in the command's directory and environment.
+func (mcmd *ReusableCommand) command(args []string) *exec.Cmd {
+	args = append(mcmd.Prefix[:len(mcmd.Prefix):len(mcmd.Prefix)], withGo(args)...)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = mcmd.Dir
+	if mcmd.Env != nil {
+		cmd.Env = append(os.Environ(), mcmd.Env...)
+	}
+	ownGroup(cmd)
+	return cmd
+}
+
+// isModFile returns true for the module files that always trigger a rebuild.
+func isModFile(name string) bool {
+	base := filepath.Base(name)
+	return base == "go.mod" || base == "go.sum"
+}
+
+// Main runs gowatch with cfg until interrupted, returning the errors that stop it
+// rather than exiting so main decides what to do with them.
+func Main(cfg Config, out io.Writer, eout io.Writer) error {
+	base := cfg
+	cfg, err := applyPreset(base, base.Preset)
+	if err != nil {
+		return err
+	}
+	// TAP is streamed like -ci, just in a format of its own.
+	ci := ciMode(cfg.CI, out) || cfg.TAP
+	if ci {
+		cfg = plainConfig(cfg)
+	}
+
+	err = setColor(cfg.Color, out)
+	if err != nil {
+		return err
+	}
+	err = setColors(cfg.Colors)
+	if err != nil {
+		return err
+	}
+	err = setThemes(cfg.Themes)
+	if err != nil {
+		return err
+	}
+	err = setLinks(cfg.Links, cfg.LinkFormat, out)
+	if err != nil {
+		return err
+	}
+	setRunningMessages(cfg.Running)
+	setLabels(cfg.Labels, cfg.Tags)
+	goCommand = cfg.Go
+
+	if cfg.Workspace {
+		if len(cfg.Modules) > 0 || cfg.Root != "" {
+			return fmt.Errorf("-workspace can't be used with -module or -root, the modules are the ones go.work uses")
+		}
+		cfg.Modules, err = workspaceModules(".")
+		if err != nil {
+			return err
+		}
+	}
+
+	logFile, err := setupLogging(cfg, eout)
+	if err != nil {
+		return err
+	}
+	if logFile != nil {
+		defer logFile.Close()
+	}
+
+	if cfg.PrintPlan {
+		return printPlan(cfg, out)
+	}
+
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("starting the file watcher: %v", err)
 	}
 
+	if cfg.EventsOnly {
+		defer watcher.Close()
+		return watchEvents(cfg, out, eout, watcher)
+	}
+
+	watchWidth()
+
 	done := make(chan bool)
 
-	builder := NewBuilder()
+	s, err := newSession(cfg, out, eout, watcher)
+	if err != nil {
+		return err
+	}
+	s.base = base
+	s.ci = ci
 
-	var bRes CommandResult
-	var tRes CommandResult
+	if cfg.Restore {
+		state, err := loadState(StateFile)
+		if err != nil {
+			fmt.Fprintln(eout, "warning: restoring the last session:", err)
+		}
+		restoreState(s.modules, state)
+	}
 
-	go func() {
-		for {
-			select {
-			case ev := <-watcher.Events:
-				if !strings.HasSuffix(ev.Name, ".go") {
-					continue
-				}
-				builder.Start()
-
-				tRes.Status = StatusDirty
-				bRes.Status = StatusDirty
-			case err := <-watcher.Errors:
-				fmt.Fprintln(eout, "error:", err)
-			case op := <-builder.testCmd.Output:
-				tRes = op
-			case op := <-builder.buildCmd.Output:
-				bRes = op
+	if cfg.Metrics != "" {
+		s.metrics = NewMetrics()
+		serveMetrics(cfg.Metrics, s.metrics, func(err error) {
+			fmt.Fprintln(eout, "error: metrics:", err)
+		})
+	}
+
+	if cfg.Syslog {
+		s.syslog, err = openSysLog()
+		if err != nil {
+			fmt.Fprintln(eout, "warning:", err)
+		} else {
+			defer s.syslog.Close()
+		}
+	}
+
+	if cfg.Socket != "" {
+		s.events, err = ListenSocket(cfg.Socket)
+		if err != nil {
+			return err
+		}
+		defer s.events.Close()
+	}
+
+	if cfg.Proxy != "" {
+		if cfg.App == "" {
+			return fmt.Errorf("-proxy needs the -app address to proxy to")
+		}
+		s.proxy, err = NewReloadProxy(cfg.App)
+		if err != nil {
+			return err
+		}
+		serveProxy(cfg.Proxy, s.proxy, func(err error) {
+			fmt.Fprintln(eout, "error: proxy:", err)
+		})
+	}
+
+	if cfg.SetupCmd != "" {
+		logger.Info("running the setup command", "cmd", cfg.SetupCmd)
+		err = runOnce(cfg.SetupCmd, cfg.Shell, out, eout)
+		if err != nil && cfg.KeepAlive {
+			logger.Error("running the setup command", "err", err)
+			fmt.Fprintln(eout, "error: setup command:", err)
+		} else if err != nil {
+			return fmt.Errorf("setup command: %v", err)
+		}
+	}
+	if cfg.TeardownCmd != "" {
+		// Deferred before the terminal is put into raw mode, so it runs after it's restored.
+		defer func() {
+			logger.Info("running the teardown command", "cmd", cfg.TeardownCmd)
+			err := runOnce(cfg.TeardownCmd, cfg.Shell, out, eout)
+			if err != nil {
+				fmt.Fprintln(eout, "error: teardown command:", err)
 			}
-			display(out, bRes, tRes)
+		}()
+	}
+
+	if cfg.EventsFrom != "" {
+		source, err := startEventSource(cfg.EventsFrom, cfg.Shell, s.external, eout, cfg.KeepAlive, s.failed)
+		if err != nil {
+			return err
 		}
-	}()
+		defer source.Stop()
+	}
+
+	keys, nextKey, restoreTerminal := readKeys(os.Stdin)
+	defer restoreTerminal()
+	s.keys, s.nextKey = keys, nextKey
+
+	// Watch the modules themselves straight away, the directories below them can take a
+	// while on slow filesystems so they're added in the background while the build runs.
+	for _, m := range s.modules {
+		err = s.watch(m.Dir)
+		if err != nil && cfg.KeepAlive {
+			// A -watch-refresh or reload may get it watched later.
+			logger.Error("watching", "dir", m.Dir, "err", err)
+			fmt.Fprintln(eout, "error:", err)
+		} else if err != nil {
+			watcher.Close()
+			return err
+		}
+	}
 
-	builder.Start()
+	err = s.watchGroups()
+	if err != nil && cfg.KeepAlive {
+		logger.Error("watching", "err", err)
+		fmt.Fprintln(eout, "error:", err)
+	} else if err != nil {
+		watcher.Close()
+		return err
+	}
 
-	err = watcher.Add(".")
+	err = s.watchFiles()
 	if err != nil {
-		log.Fatal(err)
+		watcher.Close()
+		return err
 	}
 
-	<-done
+	go s.run()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(done)
+	}()
 
+	select {
+	case <-done:
+	case err = <-s.failed:
+	}
+
+	for _, m := range s.modules {
+		m.Builder.Stop()
+	}
+	if cfg.Totals {
+		fmt.Fprintln(out, "gowatch:", s.totals.String())
+	}
+	if cfg.Summary {
+		printSessionSummary(out, &s.totals)
+	}
+	if cfg.Restore {
+		err := s.state.save(StateFile)
+		if err != nil {
+			fmt.Fprintln(eout, "error: saving the session:", err)
+		}
+	}
+	if cfg.HealthFile != "" {
+		// Fail health checks straight away rather than once the file goes stale.
+		os.Remove(cfg.HealthFile)
+	}
+	// With -watch-refresh this one may already have been replaced, the process is exiting anyway.
 	watcher.Close()
-	return nil
+	return err
 }
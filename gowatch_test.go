@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mcmd    *ReusableCommand
+		wantErr string
+	}{
+		{"no args", &ReusableCommand{Name: "Build"}, "the build command is empty"},
+		{"empty program", &ReusableCommand{Name: "Test", Args: []string{""}}, "the test command is empty"},
+		{"missing program", &ReusableCommand{Name: "Build", Args: []string{"gowatch-no-such-program"}}, "the build command can't be run"},
+		{"missing prefix", &ReusableCommand{Name: "Build", Args: []string{"go", "build"}, Prefix: []string{"gowatch-no-such-runner"}}, "the build command can't be run"},
+		{"found", &ReusableCommand{Name: "Build", Args: []string{"sh", "-c", "true"}}, ""},
+	}
+	for _, test := range tests {
+		err := test.mcmd.Validate()
+		switch {
+		case test.wantErr == "" && err != nil:
+			t.Errorf("%s: Validate() = %v, want no error", test.name, err)
+		case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+			t.Errorf("%s: Validate() = %v, want %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestStartWithEmptyArgs(t *testing.T) {
+	output := make(chan CommandResult, 1)
+	mcmd := ReusableCommand{Name: "Build", Output: output}
+	mcmd.StartWith(nil)
+	cr := <-output
+	if cr.Status != StatusBad || cr.Output != "no command to run" {
+		t.Errorf("StartWith(nil) sent %v %q, want a failure saying there's no command", cr.Status, cr.Output)
+	}
+}
+
+func TestVetArgs(t *testing.T) {
+	tests := []struct {
+		build string
+		want  string
+	}{
+		{"go build ./...", "go vet ./..."},
+		{"go build -o bin/app ./cmd/app", "go vet ./cmd/app"},
+		{"go build -p=4 ./...", "go vet ./..."},
+		{"go build -p 4 ./...", "go vet ./..."},
+		{"go build -tags integration -race ./...", "go vet ./..."},
+		{"go build -ldflags=-s ./a ./b", "go vet ./a ./b"},
+	}
+	for _, test := range tests {
+		if got := strings.Join(vetArgs(strings.Fields(test.build)), " "); got != test.want {
+			t.Errorf("vetArgs(%q) = %q, want %q", test.build, got, test.want)
+		}
+	}
+	if args := vetArgs([]string{"make"}); args != nil {
+		t.Errorf("vetArgs of a command that isn't go build = %q, want nil", args)
+	}
+}
+
+func TestNewBuilderTestArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{"passed args", Config{TestCmd: "go test ./...", Race: true, TestArgs: []string{"-run", "^X$"}}, "go test -race ./... -run ^X$"},
+		{"no cache", Config{TestCmd: "go test ./...", NoCache: true, TestArgs: []string{"-run", "^X$"}}, "go test -count=1 ./... -run ^X$"},
+		{"no args", Config{TestCmd: "go test ./...", Race: true}, "go test -race ./..."},
+		{"shell", Config{TestCmd: "go test ./...", Shell: true, TestArgs: []string{"-run", "^X$"}}, "go test ./... -run '^X$'"},
+	}
+	for _, test := range tests {
+		test.cfg.BuildCmd = "go build ./..."
+		builder, err := NewBuilder(test.cfg, t.TempDir(), make(chan CommandResult), nil)
+		if err != nil {
+			t.Fatalf("%s: NewBuilder: %v", test.name, err)
+		}
+		args := builder.testCmd.Args
+		if test.cfg.Shell {
+			// Quoted into the command the shell runs.
+			args = args[len(args)-1:]
+		}
+		if got := strings.Join(args, " "); got != test.want {
+			t.Errorf("%s: test command = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// WatchGroup is a set of directories watched besides the modules, e.g. a
+// sibling repo the code reads schemas from, with what to do when they change.
+type WatchGroup struct {
+	Name string   `yaml:"name"`
+	Dirs []string `yaml:"dirs"`
+	// Action is rebuild (everything), restart (the -run commands) or a command to run.
+	Action string `yaml:"action"`
+
+	// cmd runs the action's command, when it's one.
+	cmd *ReusableCommand
+}
+
+// setupGroups checks the groups from the config file and makes the commands
+// of those that run one, sending their results to output.
+func setupGroups(groups []WatchGroup, useShell bool, output chan CommandResult) error {
+	for i := range groups {
+		g := &groups[i]
+		if g.Name == "" || len(g.Dirs) == 0 || g.Action == "" {
+			return fmt.Errorf("groups: every group needs a name, dirs and an action")
+		}
+		for j, dir := range g.Dirs {
+			g.Dirs[j] = filepath.Clean(dir)
+		}
+		if g.Action == "rebuild" || g.Action == "restart" {
+			continue
+		}
+		g.cmd = &ReusableCommand{
+			Name:   g.Name,
+			Args:   commandArgs(g.Action, useShell),
+			Output: output,
+		}
+		err := g.cmd.Validate()
+		if err != nil {
+			return fmt.Errorf("groups: %s: %v", g.Name, err)
+		}
+	}
+	return nil
+}
+
+// groupFor returns the group watching path, or nil if it isn't in any of them.
+func groupFor(path string, groups []WatchGroup) *WatchGroup {
+	for i, g := range groups {
+		for _, dir := range g.Dirs {
+			rel, err := filepath.Rel(dir, path)
+			if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return &groups[i]
+			}
+		}
+	}
+	return nil
+}
+
+// watchGroups watches the directories of every group along with the modules.
+func (s *session) watchGroups() error {
+	for _, g := range s.groups {
+		for _, dir := range g.Dirs {
+			logger.Info("watching", "group", g.Name, "dir", dir)
+			err := s.watcher.Add(dir)
+			if err != nil {
+				return fmt.Errorf("group %s: %v", g.Name, err)
+			}
+			s.walking++
+			s.roots = append(s.roots, dir)
+			maxDepth, _ := treeDepth(s.cfg.MaxDepth, s.roots, dir)
+			watchTree(s.watcher, dir, maxDepth, s.added, s.walked)
+		}
+	}
+	return nil
+}
+
+// groupChanged carries out the action of g for a change in one of its directories.
+func (s *session) groupChanged(g *WatchGroup, path string) bool {
+	if !s.hashes.changed(path) {
+		logger.Debug("ignoring unchanged file", "name", path)
+		return false
+	}
+	logger.Info("group changed", "group", g.Name, "name", path, "action", g.Action)
+	if s.observe {
+		fmt.Fprintf(s.out, "would start  %s: in group %s, %s\n", path, g.Name, g.Action)
+		return false
+	}
+	switch g.Action {
+	case "rebuild":
+		for _, m := range s.modules {
+			s.schedule(m, trigger{})
+		}
+	case "restart":
+		for _, m := range s.modules {
+			if m.Builder.StartRun() {
+				m.Run = CommandResult{Name: m.Builder.runCmd.Name, Dir: m.Dir, Status: StatusDirty}
+				if s.proxy != nil {
+					s.proxy.ReloadWhenUp()
+				}
+			}
+		}
+	default:
+		g.cmd.Start()
+		s.notice = g.Name + ": running " + g.Action
+	}
+	return true
+}
+
+// groupFinished shows how the command of the group called name went, returning
+// false if there's no such group.
+func (s *session) groupFinished(op CommandResult) bool {
+	for _, g := range s.groups {
+		if g.cmd == nil || g.cmd.Name != op.Name || op.Dir != "" {
+			continue
+		}
+		if op.Status == StatusOk {
+			s.notice = g.Name + ": " + g.Action + " passed"
+		} else {
+			s.notice = g.Name + ": " + g.Action + " failed"
+			if op.Exit != "" {
+				s.notice += ", " + op.Exit
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// coalesceWindow is how close together events for one file must be to count as a single save.
+const coalesceWindow = 20 * time.Millisecond
+
+// A burst is burstEvents events within burstWindow, it's over after burstWindow without any.
+const (
+	burstEvents = 200
+	burstWindow = time.Second
+)
+
+// recentEvents remembers when each file last had an event.
+type recentEvents map[string]time.Time
+
+// seen records an event for path and reports whether it follows another within coalesceWindow.
+func (re recentEvents) seen(path string) bool {
+	now := time.Now()
+	for p, t := range re {
+		if now.Sub(t) > coalesceWindow {
+			delete(re, p)
+		}
+	}
+
+	path = filepath.Clean(path)
+	_, seen := re[path]
+	if !seen {
+		re[path] = now
+	}
+	return seen
+}
+
+// contentCache remembers a hash of each file's contents to spot saves that didn't change anything.
+type contentCache map[string][sha256.Size]byte
+
+// changed reports whether the file at path differs from when it was last seen and remembers its contents.
+func (cc contentCache) changed(path string) bool {
+	path = filepath.Clean(path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		// Removed or unreadable, let the build decide.
+		delete(cc, path)
+		return true
+	}
+
+	sum := sha256.Sum256(data)
+	old, seen := cc[path]
+	cc[path] = sum
+	return !seen || old != sum
+}
+
+// addDir remembers the current contents of the files in dir that can trigger a build.
+func (cc contentCache) addDir(dir string) {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	files = append(files, filepath.Join(dir, "go.mod"), filepath.Join(dir, "go.sum"))
+	for _, file := range files {
+		cc.changed(file)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// writeHealth marks gowatch as alive by writing the current time to the -health-file.
+func writeHealth(path string) error {
+	return writeFileAtomic(path, []byte(time.Now().Format(time.RFC3339)+"\n"))
+}
+
+// checkHealth is "gowatch healthcheck", it fails unless the health file at path
+// was updated within maxAge.
+func checkHealth(path string, maxAge time.Duration) error {
+	if path == "" {
+		return fmt.Errorf("healthcheck needs -health-file")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if age := time.Since(info.ModTime()); age > maxAge {
+		return fmt.Errorf("%s is stale, last updated %s ago", path, age.Round(time.Second))
+	}
+	return nil
+}
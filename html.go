@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+	"time"
+)
+
+// defaultReport is where the e key writes the report without -html.
+const defaultReport = "gowatch-report.html"
+
+// reportTemplate is the self-contained page htmlReport makes.
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"status": func(s Status) string { return s.String() },
+	"icon":   func(s Status) string { return StatusIcon[s] },
+	"round":  func(d time.Duration) time.Duration { return d.Round(time.Millisecond) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gowatch report</title>
+</head>
+<body style="background: #1e1e1e; color: #d4d4d4; font-family: sans-serif">
+<h1>gowatch report</h1>
+{{range .}}
+<h2 style="font-size: 1.1em; color: {{if eq (status .Status) "ok"}}#4ec94e{{else if eq (status .Status) "bad"}}#f14c4c{{else if eq (status .Status) "warn"}}#e59410{{else}}#e5e510{{end}}">
+{{icon .Status}} {{.Label}} <span style="color: #808080; font-weight: normal">{{.Dir}}{{if .Duration}} · {{round .Duration}}{{end}}{{if .Exit}} · {{.Exit}}{{end}}</span>
+</h2>
+{{if .Command}}<p style="color: #808080; font-family: monospace">$ {{.Command}}</p>{{end}}
+{{if .Output}}<pre style="background: #111; padding: 0.5em; overflow-x: auto">{{.Output}}</pre>{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// htmlReport makes a self-contained HTML page of results, apart from the time
+// exportHTML puts in, so reports can be compared to see if they changed.
+func htmlReport(results []CommandResult) (string, error) {
+	var buf bytes.Buffer
+	err := reportTemplate.Execute(&buf, results)
+	return buf.String(), err
+}
+
+// exportHTML writes report to path, with the time it was written under its heading.
+func exportHTML(report, path string) error {
+	heading := "<h1>gowatch report</h1>\n"
+	at := `<p style="color: #808080">` + time.Now().Format("2006-01-02 15:04:05") + "</p>\n"
+	return writeFileAtomic(path, []byte(strings.Replace(report, heading, heading+at, 1)))
+}
+
+// reportResults are the results of every module that have run, in the order they're shown.
+func reportResults(modules []*Module) []CommandResult {
+	var results []CommandResult
+	for _, m := range modules {
+		results = append(results, m.Build)
+		results = append(results, m.Targets...)
+		if m.Tidy.Name != "" {
+			results = append(results, m.Tidy)
+		}
+		if m.Builder.HasTests() {
+			results = append(results, m.Packages...)
+			results = append(results, m.Test)
+		}
+		if m.Builder.HasExamples() {
+			results = append(results, m.Examples)
+		}
+		for _, cr := range append([]CommandResult{m.Rerun, m.Run}, m.Rules...) {
+			if cr.Name != "" {
+				results = append(results, cr)
+			}
+		}
+	}
+	return results
+}
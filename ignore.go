@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultIgnore are the swap, backup and lock files editors leave next to the files being edited.
+var DefaultIgnore = []string{
+	"*.swp", "*.swo", "*.swx", // vim swap files
+	"4913",       // vim's probe for a writable directory
+	"*~",         // vim and emacs backups
+	"#*#", ".#*", // emacs auto-save and lock files
+	".*.kate-swp",      // kate
+	".goutputstream-*", // gedit
+}
+
+// ignored reports whether the base name of path matches any of patterns.
+func ignored(path string, patterns []string) bool {
+	base := filepath.Base(path)
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// generatedComment is the comment marking generated Go files, see go help generate.
+var generatedComment = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// generatedHeader is how much of a file is read looking for the comment.
+const generatedHeader = 4096
+
+// isGenerated reports whether the Go file at path says it's generated, in a
+// comment before its package clause. Files that can't be read aren't.
+func isGenerated(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	lines := bufio.NewScanner(io.LimitReader(f, generatedHeader))
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if generatedComment.MatchString(line) {
+			return true
+		}
+		if strings.HasPrefix(line, "package ") {
+			return false
+		}
+	}
+	return false
+}
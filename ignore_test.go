@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestIgnoredEditorFiles(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"main.go", false},
+		{"dir/main_test.go", false},
+		{".main.go.swp", true},
+		{"dir/.main.go.swo", true},
+		{".main.go.swx", true},
+		{"4913", true},
+		{"dir/4913", true},
+		{"49130", false},
+		{"main.go~", true},
+		{"#main.go#", true},
+		{".#main.go", true},
+		{"dir/.#main.go", true},
+		{"#main.go", false},
+		{".main.go.kate-swp", true},
+		{".goutputstream-ABC123", true},
+		{"swp.go", false},
+	}
+	for _, test := range tests {
+		if got := ignored(test.path, DefaultIgnore); got != test.want {
+			t.Errorf("ignored(%q) = %v, want %v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestIgnoredExtraPatterns(t *testing.T) {
+	patterns := append([]string{"*.tmp"}, DefaultIgnore...)
+	if !ignored("dir/build.tmp", patterns) {
+		t.Error("an -ignore pattern added to the defaults isn't ignored")
+	}
+	if ignored(".main.go.swp", nil) {
+		t.Error("a swap file is ignored with -no-default-ignore and no patterns")
+	}
+}
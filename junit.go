@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// junitSuites is the root of a JUnit XML report, with a suite for each package.
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Class   string        `xml:"classname,attr"`
+	Name    string        `xml:"name,attr"`
+	Time    string        `xml:"time,attr"`
+	Failure *junitFailure `xml:"failure"`
+	Skipped *struct{}     `xml:"skipped"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Output  string `xml:",chardata"`
+}
+
+// junitReport makes a JUnit XML report of the tests in cases, by package in
+// the order they first appear.
+func junitReport(cases []TestCase) ([]byte, error) {
+	var report junitSuites
+	suites := map[string]int{}
+	for _, tc := range cases {
+		i, found := suites[tc.Package]
+		if !found {
+			i = len(report.Suites)
+			suites[tc.Package] = i
+			report.Suites = append(report.Suites, junitSuite{Name: tc.Package})
+		}
+		suite := &report.Suites[i]
+
+		jc := junitCase{Class: tc.Package, Name: tc.Test, Time: junitTime(tc.Elapsed)}
+		switch tc.Result {
+		case "fail":
+			jc.Failure = &junitFailure{Message: "Failed", Output: tc.Output}
+			suite.Failures++
+		case "skip":
+			jc.Skipped = &struct{}{}
+			suite.Skipped++
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, jc)
+	}
+	for i := range report.Suites {
+		var total time.Duration
+		for _, tc := range cases {
+			// Subtests run within their parent's time, so only the top level tests add up.
+			if tc.Package == report.Suites[i].Name && !strings.Contains(tc.Test, "/") {
+				total += tc.Elapsed
+			}
+		}
+		report.Suites[i].Time = junitTime(total)
+	}
+
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// junitTime formats d in seconds, as JUnit has it.
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// moduleCases are the tests of every module's last run, for -junit.
+func moduleCases(modules []*Module) []TestCase {
+	var cases []TestCase
+	for _, m := range modules {
+		cases = append(cases, m.Test.Tests.Cases...)
+	}
+	return cases
+}
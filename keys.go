@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// readKeys puts the terminal in cbreak mode and sends each key pressed on the returned channel.
+// The next key isn't read until the last one is done with, sent on next, so a
+// command started for it (like the pager) gets the keys pressed meanwhile.
+// The returned function restores the terminal. No keys are read when in isn't a terminal.
+func readKeys(in *os.File) (keys <-chan byte, next chan<- bool, restore func()) {
+	saved, err := stty(in, "-g")
+	if err != nil {
+		return nil, nil, func() {}
+	}
+	_, err = stty(in, "-icanon", "-echo", "min", "1")
+	if err != nil {
+		return nil, nil, func() {}
+	}
+
+	pressed := make(chan byte)
+	done := make(chan bool)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			n, err := in.Read(buf)
+			if err != nil {
+				return
+			}
+			if n == 1 {
+				pressed <- buf[0]
+				<-done
+			}
+		}
+	}()
+
+	return pressed, done, func() {
+		stty(in, strings.TrimSpace(saved))
+	}
+}
+
+func stty(in *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = in
+	out, err := cmd.Output()
+	return string(out), err
+}
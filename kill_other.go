@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// ownGroup does nothing, there are no process groups on this platform.
+func ownGroup(cmd *exec.Cmd) {}
+
+// killGroup kills just the process pid, what it started keeps running on this platform.
+func killGroup(pid int) {
+	if p, err := os.FindProcess(pid); err == nil {
+		p.Kill()
+	}
+}
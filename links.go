@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// linkFormat is the URL error locations link to with {file}, {line} and {col}
+// filled in, empty when they aren't links.
+var linkFormat string
+
+// setLinks turns the links to error locations on or off for mode always,
+// never or auto (only on terminals known to support them).
+func setLinks(mode, format string, out io.Writer) error {
+	on := false
+	switch mode {
+	case "always":
+		on = true
+	case "never":
+	case "auto", "":
+		on = isTerminal(out) && linksSupported()
+	default:
+		return fmt.Errorf("invalid -links %q, must be always, auto or never", mode)
+	}
+	linkFormat = ""
+	if on {
+		linkFormat = format
+	}
+	return nil
+}
+
+// linksSupported guesses from the environment whether the terminal supports OSC 8 hyperlinks.
+func linksSupported() bool {
+	switch os.Getenv("TERM_PROGRAM") {
+	case "iTerm.app", "WezTerm", "vscode", "ghostty", "Hyper":
+		return true
+	}
+	if os.Getenv("KITTY_WINDOW_ID") != "" || os.Getenv("WT_SESSION") != "" {
+		return true
+	}
+	// GNOME Terminal and the other VTE based ones, since 0.50.
+	if vte, err := strconv.Atoi(os.Getenv("VTE_VERSION")); err == nil && vte >= 5000 {
+		return true
+	}
+	return false
+}
+
+// hyperlink makes label a link to where be is, for a command run in dir.
+func hyperlink(dir string, be BuildError, label string) string {
+	if linkFormat == "" {
+		return label
+	}
+	path := be.File
+	if !filepath.IsAbs(path) {
+		abs, err := filepath.Abs(filepath.Join(dir, path))
+		if err != nil {
+			return label
+		}
+		path = abs
+	}
+	col := be.Col
+	if col == 0 {
+		col = 1
+	}
+	link := strings.NewReplacer(
+		"{file}", (&url.URL{Path: filepath.ToSlash(path)}).EscapedPath(),
+		"{line}", strconv.Itoa(be.Line),
+		"{col}", strconv.Itoa(col),
+	).Replace(linkFormat)
+	return "\033]8;;" + link + "\033\\" + label + "\033]8;;\033\\"
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+)
+
+// logger records what gowatch itself is doing, it discards everything unless logging is enabled.
+var logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// setupLogging points logger at the configured destination, the returned file (if any) should be closed on exit.
+func setupLogging(cfg Config, eout io.Writer) (io.Closer, error) {
+	levelName := cfg.LogLevel
+	if cfg.Debug {
+		levelName = "debug"
+	}
+	if levelName == "" {
+		return nil, nil
+	}
+
+	var level slog.Level
+	err := level.UnmarshalText([]byte(levelName))
+	if err != nil {
+		return nil, err
+	}
+
+	w := eout
+	var closer io.Closer
+	if cfg.LogFile != "" && cfg.LogSize > 0 {
+		rf, err := openRotating(cfg.LogFile, int64(cfg.LogSize)<<20, cfg.LogKeep)
+		if err != nil {
+			return nil, err
+		}
+		w, closer = rf, rf
+	} else if cfg.LogFile != "" {
+		f, err := os.OpenFile(cfg.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w, closer = f, f
+	}
+
+	logger = slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: level}))
+	return closer, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// markdownIcon is the emoji shown for each status in the -markdown file.
+var markdownIcon = map[Status]string{
+	StatusDirty:  "⏳",
+	StatusOk:     "✅",
+	StatusBad:    "❌",
+	StatusWarn:   "⚠️",
+	StatusKilled: "✂️",
+}
+
+// markdownStatus is the heading of the -markdown file for each overall status.
+var markdownStatus = map[Status]string{
+	StatusDirty: "running",
+	StatusOk:    "passing",
+	StatusBad:   "failing",
+}
+
+// markdownReport renders results as a markdown table, followed by the output
+// of each that failed or warned in a code block of its own.
+func markdownReport(status Status, results []CommandResult) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s gowatch: %s\n\n", markdownIcon[status], markdownStatus[status])
+	b.WriteString("| | Command | Directory | Time | Result |\n")
+	b.WriteString("|---|---|---|---|---|\n")
+	for _, cr := range results {
+		took := ""
+		if cr.Duration > 0 {
+			took = cr.Duration.Round(100 * time.Millisecond).String()
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n", markdownIcon[cr.Status], markdownCell(cr.Label()), markdownCell(cr.Dir), took, markdownCell(cr.Exit))
+	}
+
+	for _, cr := range results {
+		output := strings.TrimRight(cr.Output, "\n")
+		if (cr.Status != StatusBad && cr.Status != StatusWarn) || output == "" {
+			continue
+		}
+		fence := markdownFence(output)
+		fmt.Fprintf(&b, "\n## %s %s (%s)\n\n%s\n%s\n%s\n", markdownIcon[cr.Status], cr.Label(), cr.Dir, fence, output, fence)
+	}
+	return b.String()
+}
+
+// markdownCell escapes text to go in a table cell, on one line.
+func markdownCell(text string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(text)
+}
+
+// markdownFence returns a code fence longer than any run of backticks in text, so it can't end the block early.
+func markdownFence(text string) string {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	return fence
+}
+
+// exportMarkdown writes report to path, with the time it was written under its heading.
+func exportMarkdown(report, path string) error {
+	heading, rest, _ := strings.Cut(report, "\n\n")
+	data := heading + "\n\n_" + time.Now().Format("2006-01-02 15:04:05") + "_\n\n" + rest
+	return writeFileAtomic(path, []byte(data))
+}
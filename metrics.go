@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// durationBuckets are the upper bounds in seconds of the command duration histogram.
+var durationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// metricKey identifies the command a metric is for.
+type metricKey struct {
+	command string
+	module  string
+}
+
+func (mk metricKey) labels() string {
+	return fmt.Sprintf("command=%q,module=%q", mk.command, mk.module)
+}
+
+// commandMetrics are the numbers kept for one command.
+type commandMetrics struct {
+	runs     int
+	failures int
+	passing  bool
+	buckets  []int
+	sum      float64
+}
+
+// Metrics counts the command results and serves them in the Prometheus text format.
+type Metrics struct {
+	lock     sync.Mutex
+	commands map[metricKey]*commandMetrics
+}
+
+// NewMetrics makes an empty set of metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{commands: map[metricKey]*commandMetrics{}}
+}
+
+// Record counts a finished command.
+func (m *Metrics) Record(cr CommandResult) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key := metricKey{command: strings.ToLower(cr.Name), module: cr.Dir}
+	cm := m.commands[key]
+	if cm == nil {
+		cm = &commandMetrics{buckets: make([]int, len(durationBuckets))}
+		m.commands[key] = cm
+	}
+
+	cm.runs++
+	cm.passing = cr.Status == StatusOk
+	if !cm.passing {
+		cm.failures++
+	}
+
+	seconds := cr.Duration.Seconds()
+	cm.sum += seconds
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			cm.buckets[i]++
+		}
+	}
+}
+
+// ServeHTTP writes the metrics.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var keys []metricKey
+	for key := range m.commands {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].module != keys[j].module {
+			return keys[i].module < keys[j].module
+		}
+		return keys[i].command < keys[j].command
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP gowatch_runs_total Commands run to completion.")
+	fmt.Fprintln(w, "# TYPE gowatch_runs_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "gowatch_runs_total{%s} %d\n", key.labels(), m.commands[key].runs)
+	}
+
+	fmt.Fprintln(w, "# HELP gowatch_failures_total Commands that failed.")
+	fmt.Fprintln(w, "# TYPE gowatch_failures_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "gowatch_failures_total{%s} %d\n", key.labels(), m.commands[key].failures)
+	}
+
+	fmt.Fprintln(w, "# HELP gowatch_passing Whether the last run of the command passed.")
+	fmt.Fprintln(w, "# TYPE gowatch_passing gauge")
+	for _, key := range keys {
+		passing := 0
+		if m.commands[key].passing {
+			passing = 1
+		}
+		fmt.Fprintf(w, "gowatch_passing{%s} %d\n", key.labels(), passing)
+	}
+
+	fmt.Fprintln(w, "# HELP gowatch_duration_seconds How long the commands took.")
+	fmt.Fprintln(w, "# TYPE gowatch_duration_seconds histogram")
+	for _, key := range keys {
+		cm := m.commands[key]
+		for i, bound := range durationBuckets {
+			fmt.Fprintf(w, "gowatch_duration_seconds_bucket{%s,le=\"%g\"} %d\n", key.labels(), bound, cm.buckets[i])
+		}
+		fmt.Fprintf(w, "gowatch_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", key.labels(), cm.runs)
+		fmt.Fprintf(w, "gowatch_duration_seconds_sum{%s} %g\n", key.labels(), cm.sum)
+		fmt.Fprintf(w, "gowatch_duration_seconds_count{%s} %d\n", key.labels(), cm.runs)
+	}
+}
+
+// serveMetrics serves metrics on addr in the background, calling report if the server stops.
+func serveMetrics(addr string, metrics *Metrics, report func(error)) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	go func() {
+		err := http.ListenAndServe(addr, mux)
+		report(err)
+	}()
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+// Module is a watched directory with its own builder and latest results.
+type Module struct {
+	Dir     string
+	Root    string // where the commands run, usually Dir
+	Builder *Builder
+	Build   CommandResult
+	Test    CommandResult
+	// Packages are the results of testing each package with -per-package, by name.
+	Packages []CommandResult
+	// Examples is the result of the -examples run, ok if there isn't one.
+	Examples CommandResult
+	// Rerun is the result of rerunning a single failed test, until the next build.
+	Rerun CommandResult
+	// Targets are the results of the -target builds, in the same order.
+	Targets []CommandResult
+	// Run is the result of the -run command, dirty while it's running.
+	Run CommandResult
+	// Tidy is the result of go mod tidy -diff with -tidy, a warning when it would change anything.
+	Tidy CommandResult
+	// Killed is the last command killed to start it again with -show-killed.
+	Killed CommandResult
+	// Rules are the results of the rule commands, by rule, once they've run.
+	Rules []CommandResult
+
+	// built is a passing build waiting for vet to finish in strict mode.
+	built CommandResult
+
+	// testWas and examplesWas are the statuses of the tests and examples before they were last started.
+	testWas     Status
+	examplesWas Status
+	// sameFailures is set when the tests failed just as they did the time before.
+	sameFailures bool
+
+	started time.Time
+	pending *trigger
+
+	// generating is the change waiting for the rules run before the build, nil when none are running.
+	generating *trigger
+
+	// lastSize is the size of the binaries from the last successful build.
+	lastSize int64
+
+	// changed are the files whose change started the commands last, for -blame.
+	changed []string
+
+	// restored is when the results shown were saved, with -restore, until it's built again.
+	restored time.Time
+
+	// embeds are the patterns of embedded files that trigger a rebuild, by package directory.
+	embeds map[string][]string
+
+	// deps is the dependency graph of the packages for -affected, nil until it's needed.
+	deps *depGraph
+
+	// selected are the packages picked to build and test, nil for all of them.
+	selected []string
+
+	// goVersion is the version of go the commands run with -show-go.
+	goVersion string
+
+	// since are the .go files changed since the -since ref, nil without it, and
+	// edited those changed since, both by absolute path. gitDir is the
+	// repository's .git directory, watched to see new commits.
+	since  map[string]bool
+	edited map[string]bool
+	gitDir string
+}
+
+// trigger describes which commands a change needs to rerun.
+type trigger struct {
+	onlyTests bool
+	modules   bool
+	packages  []string // nil for every package
+
+	// files are the changed files relative to the module, for the rules.
+	files []string
+
+	// vetPackages are the packages of the changed files with -incremental, nil to vet everything built.
+	vetPackages []string
+
+	// generated is set once the rules run before the build have passed.
+	generated bool
+
+	// built is set when the quick build of -full-delay has already built the
+	// change and passed, only the rest is left to run.
+	built bool
+}
+
+// merge combines two triggers into one that covers both.
+func (t trigger) merge(o trigger) trigger {
+	return trigger{
+		onlyTests:   t.onlyTests && o.onlyTests,
+		modules:     t.modules || o.modules,
+		packages:    mergePackages(t.packages, o.packages),
+		vetPackages: mergePackages(t.vetPackages, o.vetPackages),
+		files:       mergeFiles(t.files, o.files),
+		generated:   t.generated && o.generated,
+		built:       t.built && o.built,
+	}
+}
+
+// mergeFiles combines two lists of files.
+func mergeFiles(a, b []string) []string {
+	merged := append([]string(nil), a...)
+	for _, file := range b {
+		found := false
+		for _, f := range a {
+			found = found || f == file
+		}
+		if !found {
+			merged = append(merged, file)
+		}
+	}
+	return merged
+}
+
+// mergePackages combines two package lists, where nil stands for every package.
+func mergePackages(a, b []string) []string {
+	if a == nil || b == nil {
+		return nil
+	}
+	// Still none, not every package, when neither has any.
+	merged := []string{}
+	seen := map[string]bool{}
+	for _, pkgs := range [][]string{a, b} {
+		for _, pkg := range pkgs {
+			if !seen[pkg] {
+				seen[pkg] = true
+				merged = append(merged, pkg)
+			}
+		}
+	}
+	return merged
+}
+
+// start reruns the commands t needs and marks their results dirty.
+func (m *Module) start(t trigger, modDownload bool) {
+	m.started = time.Now()
+	m.pending = nil
+	if t.packages != nil && len(t.packages) == 0 {
+		m.scopedOut()
+		return
+	}
+	if m.Rules == nil {
+		m.Rules = make([]CommandResult, len(m.Builder.rules))
+	}
+	if !t.generated {
+		if started := m.Builder.StartGenerators(t.files); len(started) > 0 {
+			for _, i := range started {
+				m.Rules[i].Name = m.Builder.rules[i].Run
+				m.Rules[i].Status = StatusDirty
+			}
+			// The code they generate has to be built again.
+			t.built = false
+			m.generating = &t
+			return
+		}
+	}
+	m.Rerun = CommandResult{}
+	m.changed = t.files
+	if t.packages == nil {
+		t.packages = m.selected
+	}
+	m.Builder.vetScope = t.vetPackages
+
+	switch {
+	case t.modules && modDownload:
+		m.Builder.StartModules()
+	case t.built:
+		m.Builder.StartRest(t.packages)
+	case t.packages != nil:
+		m.Builder.StartScoped(t.onlyTests, t.packages)
+	case t.onlyTests:
+		m.Builder.StartFiltered(true)
+	default:
+		m.Builder.Start()
+	}
+
+	m.syncPackages()
+	if m.Builder.HasTests() {
+		if m.Test.Status != StatusDirty {
+			m.testWas = m.Test.Status
+		}
+		m.Test.Status = StatusDirty
+	}
+	if m.Builder.HasExamples() {
+		if m.Examples.Status != StatusDirty {
+			m.examplesWas = m.Examples.Status
+		}
+		m.Examples.Status = StatusDirty
+	}
+	if m.Builder.WaitingForBuild() {
+		// The build is still broken, they'd show as running until it's fixed.
+		m.skipTests()
+	}
+	if !t.onlyTests && !t.built {
+		m.Build.Status = StatusDirty
+		for i := range m.Targets {
+			m.Targets[i].Status = StatusDirty
+		}
+	}
+	if !t.onlyTests {
+		if m.Builder.HasTidy() {
+			m.Tidy = CommandResult{Name: m.Builder.tidyCmd.Name, Dir: m.Dir, Status: StatusDirty}
+		}
+	}
+
+	for _, i := range m.Builder.StartRules(t.files) {
+		m.Rules[i].Name = m.Builder.rules[i].Run
+		m.Rules[i].Status = StatusDirty
+	}
+}
+
+// scopedOut shows the build and tests as passing without running them when
+// there are no packages to run them on, as none has changed since the -since ref.
+func (m *Module) scopedOut() {
+	m.Builder.Kill()
+	none := func(mcmd *ReusableCommand) CommandResult {
+		return CommandResult{Name: mcmd.Name, Dir: mcmd.Dir, Status: StatusOk, Output: "no package has changed since the -since ref\n"}
+	}
+	m.Build = none(&m.Builder.buildCmd)
+	for i, mcmd := range m.Builder.targetCmds {
+		m.Targets[i] = none(mcmd)
+	}
+	if m.Builder.HasTests() {
+		m.Test = none(&m.Builder.testCmd)
+	}
+	if m.Builder.HasExamples() {
+		m.Examples = none(&m.Builder.exampleCmd)
+	}
+	if m.Tidy.Status == StatusDirty {
+		m.Tidy = CommandResult{}
+	}
+}
+
+// sincePackages returns the packages changed since the -since ref or edited
+// since, empty if there are none, or nil for every package without -since.
+func (m *Module) sincePackages() []string {
+	if m.since == nil {
+		return nil
+	}
+	files := map[string]bool{}
+	for _, changed := range []map[string]bool{m.since, m.edited} {
+		for file := range changed {
+			files[file] = true
+		}
+	}
+	pkgs := packagesOf(m.Root, files)
+	if pkgs == nil {
+		pkgs = []string{}
+	}
+	return pkgs
+}
+
+// startBuild starts just the build for t, which stays pending until the rest is started too.
+func (m *Module) startBuild(t trigger) {
+	if t.packages == nil {
+		t.packages = m.selected
+	}
+	m.Builder.StartBuild(t.packages)
+	m.started = time.Now()
+	m.changed = t.files
+	m.Build.Status = StatusDirty
+	for i := range m.Targets {
+		m.Targets[i].Status = StatusDirty
+	}
+}
+
+// generatorsDone is called as each rule run before the build finishes. Once
+// they all have, it returns the change to build, or nil if one of them failed.
+func (m *Module) generatorsDone() *trigger {
+	if m.generating == nil {
+		return nil
+	}
+	ok := true
+	for i, rule := range m.Builder.rules {
+		if !rule.Before || m.Rules[i].Name == "" {
+			continue
+		}
+		switch m.Rules[i].Status {
+		case StatusDirty:
+			return nil
+		case StatusBad:
+			ok = false
+		}
+	}
+
+	t := *m.generating
+	m.generating = nil
+	if !ok {
+		return nil
+	}
+	if m.pending != nil {
+		// Changes while generating, like the generated files.
+		t = t.merge(*m.pending)
+	}
+	t.generated = true
+	return &t
+}
+
+// skipTests goes back to the last test results when the tests that were started don't run after all.
+// Those that have never run are shown as not run, there's nothing to go back to.
+func (m *Module) skipTests() {
+	m.Test.Status = m.testWas
+	if m.Test.Status == StatusDirty {
+		m.Test = notRun(&m.Builder.testCmd)
+	}
+	if m.Builder.HasExamples() {
+		m.Examples.Status = m.examplesWas
+		if m.Examples.Status == StatusDirty {
+			m.Examples = notRun(&m.Builder.exampleCmd)
+		}
+	}
+}
+
+// notRun is the result of mcmd when it didn't run because the build failed.
+func notRun(mcmd *ReusableCommand) CommandResult {
+	return CommandResult{Name: mcmd.Name, Dir: mcmd.Dir, Status: StatusBad, Exit: "not run", Output: "not run, the build failed\n"}
+}
+
+// queue holds t back until the module may start again.
+func (m *Module) queue(t trigger) {
+	if m.pending != nil {
+		t = t.merge(*m.pending)
+	}
+	m.pending = &t
+}
+
+// moduleFor returns the module containing path, preferring the most deeply nested one.
+func moduleFor(path string, modules []*Module) *Module {
+	var found *Module
+	for _, m := range modules {
+		rel, err := filepath.Rel(m.Dir, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if found == nil || len(m.Dir) > len(found.Dir) {
+			found = m
+		}
+	}
+	return found
+}
+
+// moduleByDir returns the module whose commands run in dir.
+func moduleByDir(dir string, modules []*Module) *Module {
+	for _, m := range modules {
+		if m.Root == dir {
+			return m
+		}
+	}
+	return nil
+}
+
+// setBuilder makes b the module's builder, without tests the test results always pass.
+func (m *Module) setBuilder(b *Builder) {
+	m.Builder = b
+	m.Rules = nil
+	m.Packages = nil
+	m.generating = nil
+	m.Targets = make([]CommandResult, len(b.targetCmds))
+	for i, mcmd := range b.targetCmds {
+		m.Targets[i].Name = mcmd.Name
+	}
+	if !b.HasTests() {
+		m.Test = CommandResult{Status: StatusOk}
+	}
+	if !b.HasExamples() {
+		m.Examples = CommandResult{Status: StatusOk}
+	}
+	if !b.HasTidy() {
+		m.Tidy = CommandResult{}
+	}
+}
+
+// status combines the results of the module's commands, a failure in any is a failure.
+func (m *Module) status() Status {
+	status := StatusOk
+	results := append([]CommandResult{m.Build, m.Test, m.Examples}, m.Targets...)
+	if m.Tidy.Name != "" {
+		// Untidy only warns, but it's done once tidy is and a tidy that fails fails.
+		results = append(results, m.Tidy)
+	}
+	for _, cr := range results {
+		switch cr.Status {
+		case StatusDirty:
+			return StatusDirty
+		case StatusBad:
+			status = StatusBad
+		}
+	}
+	return status
+}
+
+// overallStatus combines the status of every module.
+func overallStatus(modules []*Module) Status {
+	status := StatusOk
+	for _, m := range modules {
+		switch m.status() {
+		case StatusDirty:
+			return StatusDirty
+		case StatusBad:
+			status = StatusBad
+		}
+	}
+	return status
+}
+
+// allDone returns true once no module has a command still running.
+func allDone(modules []*Module) bool {
+	for _, m := range modules {
+		if m.status() == StatusDirty {
+			return false
+		}
+	}
+	return true
+}
+
+// measure records the size of the binaries just built on the build result.
+func (m *Module) measure(cr *CommandResult) {
+	size, err := pathSize(m.Builder.binDir)
+	if err != nil {
+		logger.Error("measuring binaries", "dir", m.Builder.binDir, "err", err)
+		return
+	}
+	cr.Size = size
+	if m.lastSize > 0 {
+		cr.SizeDelta = size - m.lastSize
+	}
+	m.lastSize = size
+}
+
+// findEmbeds refreshes the embedded file patterns of the package in dir and watches the directories they are in.
+func (m *Module) findEmbeds(cfg Config, watcher *fsnotify.Watcher, dir string) {
+	dir = filepath.Clean(dir)
+	patterns := embedPatterns(dir)
+	if dir == filepath.Clean(m.Dir) {
+		for _, pattern := range cfg.Embeds {
+			patterns = append(patterns, filepath.Join(m.Dir, pattern))
+		}
+	}
+	if m.embeds == nil {
+		m.embeds = map[string][]string{}
+	}
+	m.embeds[dir] = patterns
+
+	for _, embedDir := range embedDirs(patterns) {
+		if filepath.Clean(embedDir) == dir {
+			continue
+		}
+		err := watcher.Add(embedDir)
+		if err != nil {
+			logger.Debug("can't watch embedded files", "dir", embedDir, "err", err)
+			continue
+		}
+		logger.Debug("watching embedded files", "dir", embedDir)
+	}
+}
+
+// embedded reports whether path is embedded by one of the module's packages.
+func (m *Module) embedded(path string) bool {
+	for _, patterns := range m.embeds {
+		if matchEmbed(path, patterns) {
+			return true
+		}
+	}
+	return false
+}
+
+// rerunFailure reruns the first failed test on its own, returning false if there's nothing to rerun.
+func (m *Module) rerunFailure() bool {
+	if len(m.Test.Tests.Failures) == 0 {
+		return false
+	}
+	f := m.Test.Tests.Failures[0]
+	if !m.Builder.Rerun(f) {
+		return false
+	}
+	m.Rerun = CommandResult{Name: "Rerun " + f.Test, Status: StatusDirty}
+	return true
+}
+
+// testSkipped tests the packages left out by -skip-tests once, shown like a rerun.
+func (m *Module) testSkipped() bool {
+	if !m.Builder.TestSkipped() {
+		return false
+	}
+	m.Rerun = CommandResult{Name: "Test skipped", Status: StatusDirty}
+	return true
+}
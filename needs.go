@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// needsGate holds commands back until the commands they need have passed, as
+// given with needs in the commands section of the config file:
+//
+//	commands:
+//	  test: { needs: [build] }
+//	  ./deploy.sh: { needs: [test, golangci-lint run] }
+//
+// The commands that don't need each other still run side by side.
+type needsGate struct {
+	lock sync.Mutex
+	// status is how the last run of each command went, by its lowercased
+	// name, StatusDirty while it's running or waiting.
+	status map[string]Status
+	// waiting are the commands held back, with the args to start them with.
+	waiting map[*ReusableCommand][]string
+}
+
+// useNeeds gives the commands the needs the policies set for them, sharing a
+// gate so they wait for each other. It fails if a command needs one that isn't
+// there or the needs go round in a circle.
+func useNeeds(policies map[string]CommandPolicy, cmds []*ReusableCommand) error {
+	byName := map[string]*ReusableCommand{}
+	for _, mcmd := range cmds {
+		if len(mcmd.Args) > 0 {
+			byName[strings.ToLower(mcmd.Name)] = mcmd
+		}
+	}
+
+	needed := false
+	for name, mcmd := range byName {
+		for _, need := range policies[name].Needs {
+			if byName[need] == nil {
+				return fmt.Errorf("commands: %s needs %q, which isn't one of the commands run", name, need)
+			}
+			mcmd.Needs = append(mcmd.Needs, need)
+			needed = true
+		}
+	}
+	if !needed {
+		return nil
+	}
+
+	// 1 while a command's needs are being followed, 2 once they've all been.
+	visited := map[string]int{}
+	var follow func(name string) error
+	follow = func(name string) error {
+		switch visited[name] {
+		case 1:
+			return fmt.Errorf("commands: the needs of %s go round in a circle", name)
+		case 2:
+			return nil
+		}
+		visited[name] = 1
+		for _, need := range byName[name].Needs {
+			err := follow(need)
+			if err != nil {
+				return err
+			}
+		}
+		visited[name] = 2
+		return nil
+	}
+	gate := &needsGate{status: map[string]Status{}, waiting: map[*ReusableCommand][]string{}}
+	for name, mcmd := range byName {
+		err := follow(name)
+		if err != nil {
+			return err
+		}
+		mcmd.needs = gate
+	}
+	return nil
+}
+
+// start runs mcmd with args once the commands it needs have passed, straight
+// away if they already have.
+func (g *needsGate) start(mcmd *ReusableCommand, args []string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.status[strings.ToLower(mcmd.Name)] = StatusDirty
+	g.waiting[mcmd] = args
+	launchAll(g.settle())
+}
+
+// finished records how the run of mcmd as cmd went, starting the commands
+// waiting for it. A run started again since doesn't count, the new one will.
+func (g *needsGate) finished(mcmd *ReusableCommand, cmd *exec.Cmd, status Status) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if cmd != nil && mcmd.superseded(cmd) {
+		return
+	}
+	g.status[strings.ToLower(mcmd.Name)] = status
+	launchAll(g.settle())
+}
+
+// drop stops mcmd waiting, it was killed. If it was running or waiting, the
+// commands that need it don't wait for it any more: that run won't pass.
+func (g *needsGate) drop(mcmd *ReusableCommand) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	delete(g.waiting, mcmd)
+	name := strings.ToLower(mcmd.Name)
+	if status, run := g.status[name]; run && status == StatusDirty {
+		g.status[name] = StatusKilled
+		launchAll(g.settle())
+	}
+}
+
+// hold stops mcmd waiting until it's started again, the commands that need it
+// carry on waiting for that run.
+func (g *needsGate) hold(mcmd *ReusableCommand) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	delete(g.waiting, mcmd)
+	g.status[strings.ToLower(mcmd.Name)] = StatusDirty
+}
+
+// settle returns the waiting commands that may start now, failing those that
+// need one that failed, and those needing them in turn. The lock must be held.
+func (g *needsGate) settle() map[*ReusableCommand][]string {
+	ready := map[*ReusableCommand][]string{}
+	for changed := true; changed; {
+		changed = false
+		for mcmd, args := range g.waiting {
+			failed, waiting := g.check(mcmd)
+			switch {
+			case failed != "":
+				delete(g.waiting, mcmd)
+				g.status[strings.ToLower(mcmd.Name)] = StatusBad
+				why := "failed"
+				if g.status[failed] == StatusKilled {
+					why = "was stopped"
+				}
+				cr := CommandResult{Name: mcmd.Name, Dir: mcmd.Dir, Status: StatusBad, Exit: "not run", Output: "not run, it needs " + failed + ", which " + why + "\n"}
+				go func(mcmd *ReusableCommand) {
+					mcmd.Output <- cr
+				}(mcmd)
+				changed = true
+			case !waiting:
+				delete(g.waiting, mcmd)
+				ready[mcmd] = args
+			}
+		}
+	}
+	return ready
+}
+
+// check returns the need of mcmd that failed, if any did, or whether one of
+// them hasn't finished yet. The lock must be held.
+func (g *needsGate) check(mcmd *ReusableCommand) (failed string, waiting bool) {
+	for _, need := range mcmd.Needs {
+		status, run := g.status[need]
+		switch {
+		case !run || status == StatusDirty:
+			waiting = true
+		case status == StatusBad || status == StatusKilled:
+			return need, false
+		}
+	}
+	return "", waiting
+}
+
+// launchAll starts the commands with their args. The lock must be held, so a
+// command started again in the meantime can't be launched twice.
+func launchAll(ready map[*ReusableCommand][]string) {
+	for mcmd, args := range ready {
+		logger.Debug("needs passed", "name", mcmd.Name, "dir", mcmd.Dir, "needs", mcmd.Needs)
+		mcmd.launch(args)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// gatedCommands returns the commands build and test, test needing build, both
+// sending their results to out.
+func gatedCommands(t *testing.T, out chan CommandResult) (build, test *ReusableCommand) {
+	t.Helper()
+	build = &ReusableCommand{Name: "Build", Args: []string{"sleep", "10"}, Output: out}
+	test = &ReusableCommand{Name: "Test", Args: []string{"true"}, Output: out}
+	err := useNeeds(map[string]CommandPolicy{"test": {Needs: []string{"build"}}}, []*ReusableCommand{build, test})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return build, test
+}
+
+// result waits for the next result sent to out, failing if none comes.
+func result(t *testing.T, out chan CommandResult) CommandResult {
+	t.Helper()
+	select {
+	case cr := <-out:
+		return cr
+	case <-time.After(5 * time.Second):
+		t.Fatal("no result")
+		return CommandResult{}
+	}
+}
+
+func TestNeedsKilled(t *testing.T) {
+	out := make(chan CommandResult)
+	build, test := gatedCommands(t, out)
+	build.Start()
+	test.Start()
+	build.Kill()
+
+	cr := result(t, out)
+	if cr.Name != "Test" || cr.Status != StatusBad || !strings.Contains(cr.Output, "which was stopped") {
+		t.Errorf("once what it needs is killed, the test gives %s %v %q, want it not run", cr.Name, cr.Status, cr.Output)
+	}
+}
+
+func TestNeedsHeld(t *testing.T) {
+	out := make(chan CommandResult)
+	build, test := gatedCommands(t, out)
+	build.Start()
+	test.Start()
+	build.Hold()
+
+	select {
+	case cr := <-out:
+		t.Fatalf("got %s %v while what it needs is held, want it still waiting", cr.Name, cr.Status)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	build.StartWith([]string{"true"})
+	for _, want := range []string{"Build", "Test"} {
+		if cr := result(t, out); cr.Name != want || cr.Status != StatusOk {
+			t.Errorf("got %s %v, want %s to pass", cr.Name, cr.Status, want)
+		}
+	}
+}
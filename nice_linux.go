@@ -0,0 +1,23 @@
+package main
+
+import "syscall"
+
+// ioprioWhoPgrp and ioprioBestEffort are from linux/ioprio.h.
+const (
+	ioprioWhoPgrp    = 2
+	ioprioBestEffort = 2 << 13
+)
+
+// setNice lowers the CPU priority of the process group pgid to nice and, when
+// it's above 0, its IO priority to the lowest best effort one like ionice -c2 -n7.
+func setNice(pgid, nice int) error {
+	err := syscall.Setpriority(syscall.PRIO_PGRP, pgid, nice)
+	if err != nil || nice <= 0 {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoPgrp, uintptr(pgid), ioprioBestEffort|7)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
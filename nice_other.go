@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// setNice does nothing, -nice only works on Unix.
+func setNice(pgid, nice int) error {
+	return nil
+}
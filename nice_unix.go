@@ -0,0 +1,11 @@
+//go:build unix && !linux
+
+package main
+
+import "syscall"
+
+// setNice lowers the CPU priority of the process group pgid to nice, the IO
+// priority can only be changed on Linux.
+func setNice(pgid, nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PGRP, pgid, nice)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// soundPlayers are the commands tried in order to play a sound file.
+var soundPlayers = []string{"afplay", "paplay", "aplay"}
+
+// notifyMaxLen is how many characters of the summary fit in a desktop notification.
+const notifyMaxLen = 200
+
+// transition is called when the overall status flips between ok and bad, keys
+// are the commands it's about, those that failed, notified is when each command
+// was last notified about and summary says what failed.
+func transition(cfg Config, from, to Status, keys []string, notified notifyTimes, summary string) {
+	logger.Info("status changed", "from", from, "to", to)
+	if cfg.NotifyLevel == "bad" && to != StatusBad {
+		return
+	}
+	if notified.throttled(cfg.NotifyEvery, keys) {
+		logger.Debug("not notifying again so soon", "commands", keys)
+		return
+	}
+	switch to {
+	case StatusOk:
+		playSound(cfg.SoundOk)
+	case StatusBad:
+		playSound(cfg.SoundFail)
+	}
+	if cfg.Notify {
+		title, body := "gowatch: PASS", "everything passes again"
+		if to == StatusBad {
+			title, body = "gowatch: FAIL", summary
+		}
+		desktopNotify(title, body)
+	}
+}
+
+// notifyTimes are when each command was last notified about, by its directory
+// and name, for -notify-throttle.
+type notifyTimes map[string]time.Time
+
+// throttled reports whether every one of the commands by keys was notified about
+// less than every ago, if not they're noted as notified about now.
+func (n notifyTimes) throttled(every time.Duration, keys []string) bool {
+	if every > 0 && len(keys) > 0 {
+		recent := true
+		for _, key := range keys {
+			if time.Since(n[key]) >= every {
+				recent = false
+			}
+		}
+		if recent {
+			return true
+		}
+	}
+	now := time.Now()
+	for _, key := range keys {
+		n[key] = now
+	}
+	return false
+}
+
+// failingKeys are the keys of the results that failed, for notifyTimes.
+func failingKeys(results []CommandResult) []string {
+	var keys []string
+	for _, cr := range results {
+		if cr.Status == StatusBad {
+			keys = append(keys, cr.Dir+"\x00"+cr.Name)
+		}
+	}
+	return keys
+}
+
+// failureSummary says in a line or two what failed first: the first error, or
+// how many tests failed and the first of them, cut down to notifyMaxLen.
+func failureSummary(results []CommandResult) string {
+	for _, cr := range results {
+		if cr.Status != StatusBad {
+			continue
+		}
+		summary := cr.Label() + " failed"
+		switch {
+		case len(cr.Errors) > 0:
+			summary += ": " + cr.Errors[0].String()
+			if more := len(cr.Errors) - 1; more > 0 {
+				summary += fmt.Sprintf(" (and %d more)", more)
+			}
+		case len(cr.Tests.Failures) > 0:
+			first := cr.Tests.Failures[0]
+			summary += ": " + strings.TrimPrefix(cr.Tests.Summary(), "FAIL: ") + ", first " + first.Test + " in " + first.Package
+		case cr.Exit != "":
+			summary += ": " + cr.Exit
+		}
+		return cutSummary(summary, notifyMaxLen)
+	}
+	return ""
+}
+
+// cutSummary cuts text down to max characters, ending it with … if anything was left out.
+func cutSummary(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+// desktopNotify shows a desktop notification in the background with
+// notify-send or, on macOS, osascript, it does nothing if neither is there.
+func desktopNotify(title, body string) {
+	var cmd *exec.Cmd
+	if path, err := exec.LookPath("notify-send"); err == nil {
+		cmd = exec.Command(path, "--app-name=gowatch", title, body)
+	} else if path, err := exec.LookPath("osascript"); err == nil {
+		cmd = exec.Command(path, "-e", fmt.Sprintf("display notification %s with title %s", appleScriptString(body), appleScriptString(title)))
+	} else {
+		logger.Debug("no way to show desktop notifications found")
+		return
+	}
+	err := cmd.Start()
+	if err != nil {
+		logger.Error("showing notification", "cmd", cmd.Args[0], "err", err)
+		return
+	}
+	go cmd.Wait()
+}
+
+// appleScriptString quotes s as an AppleScript string.
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// celebrate runs command in the background once everything has gone from red to
+// green, for -celebrate-cmd. Its output would spoil the display, so it's only logged if it fails.
+func celebrate(command string, useShell bool) {
+	args := commandArgs(command, useShell)
+	if len(args) == 0 {
+		return
+	}
+	go func() {
+		out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if err != nil {
+			logger.Error("running the celebrate command", "cmd", command, "err", err, "output", string(out))
+		}
+	}()
+}
+
+// checkNotifyLevel returns an error unless level is one of the -notify-level values.
+func checkNotifyLevel(level string) error {
+	switch level {
+	case "all", "bad", "":
+		return nil
+	}
+	return fmt.Errorf("invalid -notify-level %q, must be all or bad", level)
+}
+
+// playSound plays file in the background with the first player found, it does nothing if there isn't one.
+func playSound(file string) {
+	if file == "" {
+		return
+	}
+	for _, player := range soundPlayers {
+		path, err := exec.LookPath(player)
+		if err != nil {
+			continue
+		}
+		cmd := exec.Command(path, file)
+		err = cmd.Start()
+		if err != nil {
+			logger.Error("playing sound", "player", player, "file", file, "err", err)
+			return
+		}
+		go cmd.Wait()
+		return
+	}
+	logger.Debug("no sound player found", "file", file)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNotifyThrottled(t *testing.T) {
+	notified := notifyTimes{}
+	if notified.throttled(time.Minute, []string{"a"}) {
+		t.Error("the first notification about a is throttled")
+	}
+	if !notified.throttled(time.Minute, []string{"a"}) {
+		t.Error("a second notification about a straight away isn't throttled")
+	}
+	if notified.throttled(time.Minute, []string{"a", "b"}) {
+		t.Error("a notification also about b, not yet notified about, is throttled")
+	}
+	if notified.throttled(0, []string{"a"}) {
+		t.Error("a notification is throttled without -notify-throttle")
+	}
+	notified["a"] = time.Now().Add(-2 * time.Minute)
+	if notified.throttled(time.Minute, []string{"a"}) {
+		t.Error("a notification about a once the interval is over is throttled")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// onChangeJobs is how many -on-change-cmd commands can run at once, events
+// arriving while they all still are don't run it, so a burst can't fork thousands.
+const onChangeJobs = 4
+
+// changeHook runs the -on-change-cmd for every file event.
+type changeHook struct {
+	command  string
+	useShell bool
+	slots    chan struct{}
+}
+
+// newChangeHook returns the hook running command, or nil if there isn't one.
+func newChangeHook(command string, useShell bool) *changeHook {
+	if strings.TrimSpace(command) == "" {
+		return nil
+	}
+	return &changeHook{command: command, useShell: useShell, slots: make(chan struct{}, onChangeJobs)}
+}
+
+// run starts the command for path in the background, in place of each {} in it
+// or else after it, unless too many are running already.
+func (h *changeHook) run(path string) {
+	select {
+	case h.slots <- struct{}{}:
+	default:
+		logger.Debug("too many on change commands running, skipping", "name", path)
+		return
+	}
+
+	command := withArgs(h.command, []string{path})
+	if strings.Contains(h.command, "{}") {
+		command = withFile(h.command, path)
+	}
+	args := commandArgs(command, h.useShell)
+	go func() {
+		defer func() { <-h.slots }()
+		out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if err != nil {
+			logger.Error("running the on change command", "name", path, "err", err, "output", string(out))
+		}
+	}()
+}
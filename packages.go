@@ -0,0 +1,399 @@
+package main
+
+import (
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// packageTest prefixes the names of the commands testing a single package with -per-package.
+const packageTest = "Test "
+
+// isPackagePattern reports whether arg names packages relative to the module, like ./... or ./foo.
+func isPackagePattern(arg string) bool {
+	return arg == "." || arg == "./..." || strings.HasPrefix(arg, "./")
+}
+
+// packagePatterns splits the go test args into their package patterns, "." if
+// there are none, and the rest, with at where the patterns were among the rest.
+func packagePatterns(args []string) (patterns, rest []string, at int) {
+	at = -1
+	for _, arg := range args {
+		if isPackagePattern(arg) {
+			if at < 0 {
+				at = len(rest)
+			}
+			patterns = append(patterns, arg)
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+		at = len(rest)
+	}
+	return patterns, rest, at
+}
+
+// splitPackages returns the args testing each of pkgs, the packages the go test
+// args test (as ./ paths), by package, or nil if they couldn't be listed.
+// The packages matching -skip-tests are left out.
+func (builder *Builder) splitPackages(args, pkgs []string) map[string][]string {
+	if pkgs == nil {
+		return nil
+	}
+	_, rest, at := packagePatterns(args)
+	runs := map[string][]string{}
+	for _, pkg := range pkgs {
+		if skipped(pkg, builder.SkipTests) {
+			continue
+		}
+		run := append(append(append([]string(nil), rest[:at]...), pkg), rest[at:]...)
+		runs[pkg] = run
+	}
+	return runs
+}
+
+// listPackages returns the packages in dir matching the patterns, as ./ paths.
+func listPackages(dir string, patterns []string) ([]string, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(goCommand, append([]string{"list", "-f", "{{.Dir}}"}, patterns...)...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	pkgs := []string{}
+	for _, pkgDir := range strings.Fields(string(out)) {
+		if pkg, ok := relativePackage(root, pkgDir); ok {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	return pkgs, nil
+}
+
+// packageList is the packages listed in the background for the tests to start
+// with args, by the key of their patterns. gen tells if other tests were
+// started since, which these don't start over.
+type packageList struct {
+	builder *Builder
+	key     string
+	pkgs    []string
+	err     error
+	args    []string
+	gen     int
+}
+
+// ForgetPackages drops the packages listed so far, they're listed again the
+// next time the tests start. A change to go.mod or a new directory may add some.
+func (builder *Builder) ForgetPackages() {
+	builder.listed = map[string][]string{}
+}
+
+// needsPackages reports whether the packages args tests have to be listed
+// before the tests can start, to split them up for PerPackage or leave out
+// the SkipTests ones from go test.
+func (builder *Builder) needsPackages(args []string) bool {
+	return builder.PerPackage || len(builder.SkipTests) > 0 && isGoTest(args)
+}
+
+// isGoTest reports whether args are a go test command.
+func isGoTest(args []string) bool {
+	return len(args) >= 2 && args[0] == "go" && args[1] == "test"
+}
+
+// runTests starts the tests with args once it knows their packages, if it
+// needs to. go list only runs the first time for each set of patterns, until
+// ForgetPackages, and in the background with Lists, to start the tests once
+// the loop gets the packages back.
+func (builder *Builder) runTests(args []string) {
+	// These supersede any tests still waiting for their packages.
+	builder.listing++
+	if !builder.needsPackages(args) {
+		builder.startListed(args, nil)
+		return
+	}
+	patterns, _, _ := packagePatterns(args)
+	key := strings.Join(patterns, " ")
+	if pkgs, found := builder.listed[key]; found {
+		builder.startListed(args, pkgs)
+		return
+	}
+	dir, gen := builder.testCmd.Dir, builder.listing
+	if builder.Lists == nil {
+		pkgs, err := listPackages(dir, patterns)
+		builder.PackagesListed(packageList{builder, key, pkgs, err, args, gen})
+		return
+	}
+	logger.Debug("listing the packages to test", "dir", dir, "patterns", patterns)
+	go func() {
+		pkgs, err := listPackages(dir, patterns)
+		builder.Lists <- packageList{builder, key, pkgs, err, args, gen}
+	}()
+}
+
+// PackagesListed keeps the packages listed for the tests and starts them,
+// unless other tests were started or everything killed since.
+func (builder *Builder) PackagesListed(l packageList) {
+	if l.err != nil {
+		// Not kept, they're listed again next time.
+		logger.Error("listing the packages to test", "dir", builder.testCmd.Dir, "err", l.err)
+	} else {
+		builder.listed[l.key] = l.pkgs
+	}
+	if l.gen == builder.listing {
+		builder.startListed(l.args, l.pkgs)
+	}
+}
+
+// startListed starts the tests with args, split into a command per package
+// with PerPackage, pkgs being the packages they test, nil if they couldn't be listed.
+// With KeepOthers the packages args doesn't test carry on, and count as tested.
+func (builder *Builder) startListed(args, pkgs []string) {
+	if !builder.PerPackage && len(builder.SkipTests) > 0 {
+		args = builder.withoutSkipped(args, pkgs)
+		if args == nil {
+			builder.testCmd.Kill()
+			builder.skipAll()
+			return
+		}
+	}
+	if !builder.PerPackage {
+		builder.testCmd.StartWith(args)
+		return
+	}
+	runs := builder.splitPackages(args, pkgs)
+	if runs != nil && len(runs) == 0 && len(builder.SkipTests) > 0 {
+		builder.killPackages()
+		builder.tested = map[string]bool{}
+		builder.skipAll()
+		return
+	}
+	if !builder.KeepOthers || len(runs) == 0 {
+		builder.killPackages()
+	}
+	if len(runs) == 0 {
+		// Test them together after all, go test will say what's wrong.
+		builder.testCmd.StartWith(args)
+		return
+	}
+
+	builder.tested = map[string]bool{}
+	for pkg := range builder.testing {
+		if runs[pkg] == nil {
+			builder.tested[pkg] = true
+		}
+	}
+	for pkg, run := range runs {
+		mcmd := builder.pkgCmds[pkg]
+		if mcmd == nil {
+			mcmd = &ReusableCommand{
+				Name:   packageTest + pkg,
+				Dir:    builder.testCmd.Dir,
+				Output: builder.testCmd.Output,
+				Prefix: builder.testCmd.Prefix,
+				Env:    builder.testCmd.Env,
+				Nice:   builder.testCmd.Nice,
+				jobs:   builder.pkgJobs,
+
+				ShowKilled: builder.testCmd.ShowKilled,
+				Timeout:    builder.testCmd.Timeout,
+				Retries:    builder.testCmd.Retries,
+				Needs:      builder.testCmd.Needs,
+				needs:      builder.testCmd.needs,
+			}
+			builder.pkgCmds[pkg] = mcmd
+		}
+		builder.testing[pkg] = true
+		builder.tested[pkg] = true
+		mcmd.StartWith(run)
+	}
+}
+
+// skipped reports whether pkg, a ./ path, matches any of the -skip-tests
+// patterns, a package or, ending in /..., the packages below it too.
+func skipped(pkg string, patterns []string) bool {
+	pkg = strings.TrimPrefix(pkg, "./")
+	for _, pattern := range patterns {
+		pattern = path.Clean(strings.TrimPrefix(filepath.ToSlash(pattern), "./"))
+		if pattern == "..." {
+			return true
+		}
+		if below, all := strings.CutSuffix(pattern, "/..."); all && (pkg == below || strings.HasPrefix(pkg, below+"/")) {
+			return true
+		}
+		if pkg == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutSkipped returns the go test args with pkgs, the packages they test,
+// in place of their patterns, apart from those -skip-tests leaves out, or nil if
+// that's all of them. They're left as they are if the packages couldn't be listed.
+func (builder *Builder) withoutSkipped(args, pkgs []string) []string {
+	if !isGoTest(args) {
+		return args
+	}
+	runs := builder.splitPackages(args, pkgs)
+	if runs == nil {
+		return args
+	}
+	if len(runs) == 0 {
+		return nil
+	}
+	var kept []string
+	for pkg := range runs {
+		kept = append(kept, pkg)
+	}
+	sort.Strings(kept)
+	return withPackages(args, kept)
+}
+
+// withPackages returns args with pkgs in place of the package patterns, where
+// the first of them was or at the end if there are none.
+func withPackages(args []string, pkgs []string) []string {
+	var kept []string
+	listed := false
+	for _, arg := range args {
+		if !isPackagePattern(arg) {
+			kept = append(kept, arg)
+		} else if !listed {
+			kept = append(kept, pkgs...)
+			listed = true
+		}
+	}
+	if !listed {
+		kept = append(kept, pkgs...)
+	}
+	return kept
+}
+
+// TestSkipped runs the tests of the packages -skip-tests leaves out once, as a
+// rerun. It returns false if none are left out or the test command isn't go test.
+func (builder *Builder) TestSkipped() bool {
+	args := builder.testCmd.Args
+	if len(builder.SkipTests) == 0 || !isGoTest(args) {
+		return false
+	}
+	var pkgs []string
+	for _, pattern := range builder.SkipTests {
+		pkgs = append(pkgs, "./"+path.Clean(strings.TrimPrefix(filepath.ToSlash(pattern), "./")))
+	}
+	builder.rerunCmd.StartWith(withPackages(args, pkgs))
+	return true
+}
+
+// skipAll reports the tests as passing without running them, when -skip-tests leaves nothing to test.
+func (builder *Builder) skipAll() {
+	mcmd := &builder.testCmd
+	if mcmd.needs != nil {
+		mcmd.needs.finished(mcmd, nil, StatusOk)
+	}
+	go func() {
+		mcmd.Output <- CommandResult{Name: mcmd.Name, Dir: mcmd.Dir, Status: StatusOk, Output: "every package is left out by -skip-tests"}
+	}()
+}
+
+// killPackages kills the tests of every package, and drops those still waiting
+// for their packages to be listed.
+func (builder *Builder) killPackages() {
+	builder.listing++
+	for pkg, mcmd := range builder.pkgCmds {
+		mcmd.Kill()
+		delete(builder.testing, pkg)
+	}
+}
+
+// newPackageJobs returns the limit on how many packages are tested at once,
+// the -max-jobs one if there is one.
+func newPackageJobs(jobs chan struct{}) chan struct{} {
+	if jobs != nil {
+		return jobs
+	}
+	return make(chan struct{}, runtime.NumCPU())
+}
+
+// PackageFinished records that the tests of the package named by the command
+// name have finished, returning true once every package's have.
+func (builder *Builder) PackageFinished(name string) bool {
+	delete(builder.testing, strings.TrimPrefix(name, packageTest))
+	return len(builder.testing) == 0
+}
+
+// Testing reports whether the tests of pkg are running with PerPackage.
+func (builder *Builder) Testing(pkg string) bool {
+	return builder.testing[pkg]
+}
+
+// isPackageTest reports whether name is that of the command testing a single package.
+func (builder *Builder) isPackageTest(name string) bool {
+	return builder.PerPackage && strings.HasPrefix(name, packageTest) && builder.pkgCmds[strings.TrimPrefix(name, packageTest)] != nil
+}
+
+// packageFinished records the result of testing a single package, and once
+// every package is done returns their results combined as the test result.
+func (m *Module) packageFinished(op CommandResult) (CommandResult, bool) {
+	i := sort.Search(len(m.Packages), func(i int) bool { return m.Packages[i].Name >= op.Name })
+	if i < len(m.Packages) && m.Packages[i].Name == op.Name {
+		m.Packages[i] = op
+	} else {
+		m.Packages = append(m.Packages[:i], append([]CommandResult{op}, m.Packages[i:]...)...)
+	}
+	if !m.Builder.PackageFinished(op.Name) {
+		return CommandResult{}, false
+	}
+
+	combined := CommandResult{Name: m.Builder.testCmd.Name, Dir: op.Dir, Status: StatusOk}
+	failed := 0
+	var outputs []string
+	for _, cr := range m.Packages {
+		combined.Duration += cr.Duration
+		combined.CPU += cr.CPU
+		combined.Tests.Packages = append(combined.Tests.Packages, cr.Tests.Packages...)
+		combined.Tests.Failures = append(combined.Tests.Failures, cr.Tests.Failures...)
+		combined.Tests.Cases = append(combined.Tests.Cases, cr.Tests.Cases...)
+		combined.Tests.Races += cr.Tests.Races
+		combined.Errors = append(combined.Errors, cr.Errors...)
+		if cr.Status == StatusBad {
+			combined.Status = StatusBad
+			failed++
+			outputs = append(outputs, cr.Output)
+		}
+	}
+	if failed > 0 {
+		combined.Exit = strconv.Itoa(failed) + " of " + plural(len(combined.Tests.Packages), "package") + " failed"
+	}
+	combined.Output = strings.Join(outputs, "\n")
+	return combined, true
+}
+
+// syncPackages keeps the results of the packages tested last, marking those being tested as running.
+func (m *Module) syncPackages() {
+	if !m.Builder.PerPackage {
+		return
+	}
+	var kept []CommandResult
+	for _, cr := range m.Packages {
+		if m.Builder.tested[strings.TrimPrefix(cr.Name, packageTest)] {
+			kept = append(kept, cr)
+		}
+	}
+	m.Packages = kept
+	for pkg := range m.Builder.testing {
+		name := packageTest + pkg
+		i := sort.Search(len(m.Packages), func(i int) bool { return m.Packages[i].Name >= name })
+		if i == len(m.Packages) || m.Packages[i].Name != name {
+			m.Packages = append(m.Packages[:i], append([]CommandResult{{Name: name, Dir: m.Root}}, m.Packages[i:]...)...)
+		}
+		m.Packages[i].Status = StatusDirty
+	}
+}
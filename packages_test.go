@@ -0,0 +1,93 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSkipped(t *testing.T) {
+	tests := []struct {
+		pkg      string
+		patterns []string
+		want     bool
+	}{
+		{"./slow", []string{"./slow"}, true},
+		{"./slow", []string{"slow"}, true},
+		{"./slow/sub", []string{"./slow"}, false},
+		{"./slow/sub", []string{"./slow/..."}, true},
+		{"./slow", []string{"./slow/..."}, true},
+		{"./slowly", []string{"./slow/..."}, false},
+		{".", []string{"./..."}, true},
+		{"./fast", nil, false},
+	}
+	for _, test := range tests {
+		if got := skipped(test.pkg, test.patterns); got != test.want {
+			t.Errorf("skipped(%q, %q) = %v, want %v", test.pkg, test.patterns, got, test.want)
+		}
+	}
+}
+
+func TestListPackages(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "go.mod"), "module example.com/m\n")
+	writeFile(t, filepath.Join(dir, "a/a.go"), "package a\n")
+	lists := make(chan packageList, 1)
+	builder := &Builder{testCmd: ReusableCommand{Dir: dir}, listed: map[string][]string{}, PerPackage: true, Lists: lists}
+
+	builder.runTests([]string{"go", "test", "./..."})
+	l := <-lists
+	if got := strings.Join(l.pkgs, " "); l.err != nil || got != "./a" {
+		t.Fatalf("packages listed = %q, %v, want ./a", got, l.err)
+	}
+	// Killed while they were being listed, the tests don't start.
+	builder.killPackages()
+	builder.PackagesListed(l)
+	if len(builder.pkgCmds) > 0 {
+		t.Errorf("PackagesListed started the tests after they were killed")
+	}
+	if got := strings.Join(builder.listed["./..."], " "); got != "./a" {
+		t.Errorf("packages kept = %q, want ./a", got)
+	}
+	builder.ForgetPackages()
+	if _, found := builder.listed["./..."]; found {
+		t.Errorf("ForgetPackages kept the packages listed")
+	}
+}
+
+func TestWithPackages(t *testing.T) {
+	tests := []struct {
+		args string
+		want string
+	}{
+		{"go test ./... -count 1", "go test ./a ./b -count 1"},
+		{"go test -race . ./x", "go test -race ./a ./b"},
+		{"go test -race", "go test -race ./a ./b"},
+	}
+	for _, test := range tests {
+		if got := strings.Join(withPackages(strings.Fields(test.args), []string{"./a", "./b"}), " "); got != test.want {
+			t.Errorf("withPackages(%q) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+func TestSkipTestsListed(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "go.mod"), "module example.com/m\n")
+	writeFile(t, filepath.Join(dir, "a/a.go"), "package a\n")
+	writeFile(t, filepath.Join(dir, "slow/slow.go"), "package slow\n")
+	lists := make(chan packageList, 1)
+	builder := &Builder{testCmd: ReusableCommand{Dir: dir}, listed: map[string][]string{}, SkipTests: []string{"./slow"}, Lists: lists}
+
+	builder.runTests([]string{"go", "test", "./..."})
+	l := <-lists
+	if got := strings.Join(l.pkgs, " "); got != "./a ./slow" {
+		t.Errorf("packages listed = %q, want ./a ./slow", got)
+	}
+	if got := strings.Join(builder.withoutSkipped(l.args, l.pkgs), " "); got != "go test ./a" {
+		t.Errorf("withoutSkipped = %q, want go test ./a", got)
+	}
+	if builder.needsPackages([]string{"make", "test"}) {
+		t.Errorf("needsPackages of a command that isn't go test = true, want false")
+	}
+}
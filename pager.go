@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// defaultPager is used when $PAGER isn't set, -R keeps any colors in the output.
+const defaultPager = "less -R"
+
+// pageFailure shows the output of the first failed command in results in $PAGER, or
+// less, returning once it's closed.
+func pageFailure(results []CommandResult) error {
+	var failed *CommandResult
+	for i := range results {
+		if results[i].Status == StatusBad && results[i].Output != "" {
+			failed = &results[i]
+			break
+		}
+	}
+	if failed == nil {
+		return fmt.Errorf("nothing has failed")
+	}
+
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = defaultPager
+	}
+	args := commandArgs(pager, false)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(failed.Output)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
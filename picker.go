@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// pickEntry is a package that can be selected in the picker.
+type pickEntry struct {
+	module *Module
+	pkg    string
+}
+
+// picker is the state of the interactive package selection, loading until
+// the packages have been listed.
+type picker struct {
+	entries   []pickEntry
+	selected  map[int]bool
+	number    string
+	cancelled bool
+	loading   bool
+}
+
+// pickList is the packages listed for the picker p, those of each module in
+// the same order as the modules.
+type pickList struct {
+	p    *picker
+	pkgs [][]string
+	err  error
+}
+
+// newPicker opens the picker loading, listing the packages of every module in the
+// background as go list can take a while, sending them to lists for fill.
+func newPicker(modules []*Module, lists chan<- pickList) *picker {
+	p := &picker{selected: map[int]bool{}, loading: true}
+	var roots []string
+	for _, m := range modules {
+		roots = append(roots, m.Root)
+	}
+	go func() {
+		l := pickList{p: p}
+		for _, root := range roots {
+			cmd := exec.Command(goCommand, "list", "./...")
+			cmd.Dir = root
+			out, err := cmd.Output()
+			if err != nil {
+				l.err = fmt.Errorf("go list in %s: %v", root, err)
+				break
+			}
+			l.pkgs = append(l.pkgs, strings.Fields(string(out)))
+		}
+		lists <- l
+	}()
+	return p
+}
+
+// fill shows the packages listed for every module, with the currently selected ones ticked.
+func (p *picker) fill(modules []*Module, pkgs [][]string) {
+	p.loading = false
+	for i, m := range modules {
+		current := map[string]bool{}
+		for _, pkg := range m.selected {
+			current[pkg] = true
+		}
+		for _, pkg := range pkgs[i] {
+			if current[pkg] {
+				p.selected[len(p.entries)] = true
+			}
+			p.entries = append(p.entries, pickEntry{module: m, pkg: pkg})
+		}
+	}
+}
+
+// key handles a key press, returning true once the picker is finished.
+// While it's loading it can only be cancelled.
+func (p *picker) key(b byte) bool {
+	if p.loading && b != 'q' && b != 27 {
+		return false
+	}
+	switch {
+	case b >= '0' && b <= '9':
+		p.number += string(b)
+	case b == 127 || b == '\b':
+		if p.number != "" {
+			p.number = p.number[:len(p.number)-1]
+		}
+	case b == 'a':
+		p.selected = map[int]bool{}
+	case b == 'q' || b == 27:
+		p.cancelled = true
+		return true
+	case b == '\n' || b == '\r':
+		if p.number == "" {
+			return true
+		}
+		n, _ := strconv.Atoi(p.number)
+		p.number = ""
+		if n >= 1 && n <= len(p.entries) {
+			p.selected[n-1] = !p.selected[n-1]
+		}
+	}
+	return false
+}
+
+// apply scopes each module to its selected packages, or all of them when none are selected.
+func (p *picker) apply(modules []*Module) {
+	for _, m := range modules {
+		m.selected = nil
+	}
+	for i, entry := range p.entries {
+		if p.selected[i] {
+			entry.module.selected = append(entry.module.selected, entry.pkg)
+		}
+	}
+}
+
+func (p *picker) render(out io.Writer) {
+	clear(out)
+	fmt.Fprintln(out, normal("Packages to build and test:"))
+	if p.loading {
+		fmt.Fprintln(out, dim("listing the packages with go list…"))
+		fmt.Fprint(out, dim("q cancels"))
+		return
+	}
+	for i, entry := range p.entries {
+		box := "[ ]"
+		if p.selected[i] {
+			box = ok("[x]")
+		}
+		fmt.Fprintf(out, "%s %3d %s\n", box, i+1, entry.pkg)
+	}
+	fmt.Fprintln(out, dim("number+enter toggles, a selects all, enter when done, q cancels"))
+	fmt.Fprint(out, normal("> "+p.number))
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestPickerLoading(t *testing.T) {
+	m := &Module{Root: ".", selected: []string{"example.com/m/b"}}
+	p := &picker{selected: map[int]bool{}, loading: true}
+	if p.key('\n') {
+		t.Errorf("enter finished the picker while it was loading")
+	}
+
+	p.fill([]*Module{m}, [][]string{{"example.com/m/a", "example.com/m/b"}})
+	if len(p.entries) != 2 || p.selected[0] || !p.selected[1] {
+		t.Errorf("fill = %v selecting %v, want both packages with b selected", p.entries, p.selected)
+	}
+	p.key('1')
+	p.key('\n')
+	if !p.key('\n') {
+		t.Fatalf("enter didn't finish the picker")
+	}
+	p.apply([]*Module{m})
+	if len(m.selected) != 2 {
+		t.Errorf("selected after picking a too = %q, want both packages", m.selected)
+	}
+}
+
+func TestPickerCancelLoading(t *testing.T) {
+	p := &picker{selected: map[int]bool{}, loading: true}
+	if !p.key('q') || !p.cancelled {
+		t.Errorf("q didn't cancel the picker while it was loading")
+	}
+}
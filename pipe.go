@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// pipeErrors lists the errors from the results one per line, for -pipe.
+func pipeErrors(results ...CommandResult) string {
+	var b strings.Builder
+	for _, cr := range results {
+		for _, be := range cr.Errors {
+			// Paths are relative to the directory the command ran in.
+			if !filepath.IsAbs(be.File) {
+				be.File = filepath.Join(cr.Dir, be.File)
+			}
+			b.WriteString(be.String() + "\n")
+		}
+	}
+	return b.String()
+}
+
+// writePipe writes errors to the FIFO at path, reporting whether it did.
+// Nothing is written if no editor currently has the FIFO open for reading.
+func writePipe(path, errs string) (bool, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		if errors.Is(err, syscall.ENXIO) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	w.WriteString(errs)
+	return true, w.Flush()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// watchPlan is what -print-plan prints: what gowatch would watch, what it
+// would react to and what it would run.
+type watchPlan struct {
+	Config      string       `json:"config,omitempty"`
+	Triggers    []string     `json:"triggers"`
+	Include     []string     `json:"include,omitempty"`
+	TestOnly    []string     `json:"test_only,omitempty"`
+	Ignore      []string     `json:"ignore"`
+	Generated   bool         `json:"watch_generated"`
+	TriggerFile string       `json:"trigger_file,omitempty"`
+	File        string       `json:"file,omitempty"`
+	EventsFrom  string       `json:"events_from,omitempty"`
+	Modules     []modulePlan `json:"modules"`
+}
+
+// modulePlan is a module of the watchPlan.
+type modulePlan struct {
+	Dir      string           `json:"dir"`
+	Root     string           `json:"root"`
+	Watched  []string         `json:"watched"`
+	GoFiles  int              `json:"go_files"`
+	Commands []plannedCommand `json:"commands"`
+}
+
+// plannedCommand is a command a module would run, as a shell command.
+type plannedCommand struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+	// When describes when it runs if not after every change.
+	When string `json:"when,omitempty"`
+	// Needs are the commands it waits for.
+	Needs []string `json:"needs,omitempty"`
+}
+
+// printPlan writes the watchPlan of cfg to out as JSON, walking the modules
+// the way they'd be watched but without watching or running anything.
+func printPlan(cfg Config, out io.Writer) error {
+	plan := watchPlan{
+		Triggers:    []string{"*.go", "go.mod", "go.sum", "testdata/**"},
+		Include:     cfg.Include,
+		TestOnly:    cfg.TestOnly,
+		Ignore:      cfg.Ignore,
+		Generated:   cfg.WatchGen,
+		TriggerFile: cfg.TriggerFile,
+		File:        cfg.File,
+		EventsFrom:  cfg.EventsFrom,
+	}
+	if _, err := os.Stat(cfg.ConfigFile); err == nil {
+		plan.Config = cfg.ConfigFile
+	}
+	for _, rule := range cfg.Rules {
+		if rule.Before {
+			plan.Triggers = append(plan.Triggers, rule.Files)
+		}
+	}
+
+	dirs := cfg.Modules
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+	for _, dir := range dirs {
+		root := dir
+		if cfg.Root != "" {
+			root = cfg.Root
+		}
+		builder, err := NewBuilder(cfg, root, nil, nil)
+		if err != nil {
+			return err
+		}
+		builder.cleanUp()
+
+		mp := modulePlan{Dir: dir, Root: root, Watched: []string{}, Commands: builder.plannedCommands(cfg.ModDownload)}
+		switch {
+		case cfg.EventsFrom != "":
+			// Nothing is watched, the command reports the changes.
+		case cfg.File != "":
+			mp.Watched = append(mp.Watched, cfg.File)
+		default:
+			roots := []string{dir}
+			if len(cfg.Watch) > 0 {
+				roots = watchRoots(dir, cfg.Watch)
+			}
+			var walked []string
+			for _, root := range roots {
+				walked = append(walked, root)
+				maxDepth, _ := treeDepth(cfg.MaxDepth, walked, root)
+				goFiles, err := walkTree(root, maxDepth, func(path string) error {
+					mp.Watched = append(mp.Watched, path)
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				mp.GoFiles += goFiles
+			}
+		}
+		plan.Modules = append(plan.Modules, mp)
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(plan)
+}
+
+// plannedCommands lists the commands the builder runs, including go mod download with modDownload.
+func (builder *Builder) plannedCommands(modDownload bool) []plannedCommand {
+	var cmds []plannedCommand
+	add := func(mcmd *ReusableCommand, when string) {
+		if len(mcmd.Args) == 0 {
+			return
+		}
+		args := append(append([]string(nil), mcmd.Prefix...), withGo(mcmd.Args)...)
+		cmds = append(cmds, plannedCommand{Name: mcmd.Name, Command: commandLine(mcmd.Dir, envFor(mcmd.Env), args), When: when, Needs: mcmd.Needs})
+	}
+	if modDownload {
+		add(&builder.modCmd, "when go.mod or go.sum change")
+	}
+	add(&builder.buildCmd, "")
+	for _, mcmd := range builder.targetCmds {
+		add(mcmd, "")
+	}
+	add(&builder.tidyCmd, "")
+	if builder.Strict {
+		add(&builder.vetCmd, "after each passing build")
+	}
+	add(&builder.testCmd, "")
+	add(&builder.exampleCmd, "")
+	add(&builder.runCmd, "after each passing build")
+	for i, rule := range builder.rules {
+		when := "when " + rule.Files + " change"
+		if rule.Before {
+			when += ", before the build"
+		}
+		add(builder.ruleCmds[i], when)
+	}
+	return cmds
+}
+
+// envFor is the environment a command with the extra variables env runs in.
+func envFor(env []string) []string {
+	if env == nil {
+		return nil
+	}
+	return append(os.Environ(), env...)
+}
+
+// cleanUp removes the temporary files the builder made for itself, for a builder that's never run.
+func (builder *Builder) cleanUp() {
+	if builder.binDir != "" && builder.binDir != builder.OutputPath {
+		os.RemoveAll(builder.binDir)
+	}
+	if builder.coverTemp != "" {
+		os.Remove(builder.coverTemp)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reloadPath is where the injected script listens for reloads, it's never passed on to the app.
+const reloadPath = "/__gowatch/reload"
+
+// reloadScript is added to every HTML page to reload it when told to.
+const reloadScript = `<script>new EventSource("` + reloadPath + `").onmessage = function() { location.reload() }</script>`
+
+// appStartTimeout is how long the app gets to start listening again after a restart.
+const appStartTimeout = 30 * time.Second
+
+// ReloadProxy forwards requests to the app, adding a script to its HTML pages
+// that reloads them whenever the app has been restarted.
+type ReloadProxy struct {
+	app   string
+	proxy *httputil.ReverseProxy
+
+	lock    sync.Mutex
+	clients map[chan bool]bool
+	waiting bool
+}
+
+// NewReloadProxy makes a proxy for the app listening on addr, e.g. ":8080".
+func NewReloadProxy(addr string) (*ReloadProxy, error) {
+	host := addr
+	if strings.HasPrefix(host, ":") {
+		host = "localhost" + host
+	}
+	target, err := url.Parse("http://" + host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -app %q: %v", addr, err)
+	}
+
+	rp := &ReloadProxy{app: target.Host, clients: map[chan bool]bool{}}
+	rp.proxy = httputil.NewSingleHostReverseProxy(target)
+	director := rp.proxy.Director
+	rp.proxy.Director = func(r *http.Request) {
+		director(r)
+		// The script can only be added to uncompressed pages.
+		r.Header.Del("Accept-Encoding")
+	}
+	rp.proxy.ModifyResponse = injectReload
+	return rp, nil
+}
+
+// ServeHTTP serves the reload events, proxying everything else.
+func (rp *ReloadProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != reloadPath {
+		rp.proxy.ServeHTTP(w, r)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+
+	reload := make(chan bool, 1)
+	rp.lock.Lock()
+	rp.clients[reload] = true
+	rp.lock.Unlock()
+	defer func() {
+		rp.lock.Lock()
+		delete(rp.clients, reload)
+		rp.lock.Unlock()
+	}()
+
+	select {
+	case <-reload:
+		fmt.Fprint(w, "data: reload\n\n")
+		flusher.Flush()
+	case <-r.Context().Done():
+	}
+}
+
+// ReloadWhenUp reloads the pages once the restarted app accepts connections again.
+func (rp *ReloadProxy) ReloadWhenUp() {
+	rp.lock.Lock()
+	if rp.waiting {
+		rp.lock.Unlock()
+		return
+	}
+	rp.waiting = true
+	rp.lock.Unlock()
+
+	go func() {
+		deadline := time.Now().Add(appStartTimeout)
+		for time.Now().Before(deadline) {
+			// Sleep first so the app that was just killed can't answer.
+			time.Sleep(100 * time.Millisecond)
+			conn, err := net.DialTimeout("tcp", rp.app, time.Second)
+			if err == nil {
+				conn.Close()
+				break
+			}
+		}
+
+		rp.lock.Lock()
+		defer rp.lock.Unlock()
+		rp.waiting = false
+		logger.Debug("reloading pages", "clients", len(rp.clients))
+		for reload := range rp.clients {
+			select {
+			case reload <- true:
+			default:
+			}
+		}
+	}()
+}
+
+// injectReload adds the reload script to HTML pages.
+func injectReload(resp *http.Response) error {
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") || resp.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	if i := bytes.LastIndex(bytes.ToLower(body), []byte("</body>")); i >= 0 {
+		body = append(body[:i:i], append([]byte(reloadScript), body[i:]...)...)
+	} else {
+		body = append(body, reloadScript...)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// serveProxy serves rp on addr in the background, report is called if it stops.
+func serveProxy(addr string, rp *ReloadProxy, report func(error)) {
+	go func() {
+		report(http.ListenAndServe(addr, rp))
+	}()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// rotatingFile is an append only file that's moved aside to path.1, path.2, ...
+// once it grows past maxSize, only the newest keep of those are kept.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	keep    int
+
+	f    *os.File
+	size int64
+}
+
+// openRotating opens the file at path for appending, rotating it once it reaches maxSize bytes.
+func openRotating(path string, maxSize int64, keep int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, keep: keep}
+	err := rf.open()
+	if err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, info.Size()
+	return nil
+}
+
+// Write appends p, rotating the file first if p would take it past the maximum size.
+// It isn't safe for concurrent use, slog's handlers already serialize their writes.
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		err := rf.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the old files along, dropping the oldest, and starts a new file.
+func (rf *rotatingFile) rotate() error {
+	err := rf.f.Close()
+	if err != nil {
+		return err
+	}
+	if rf.keep < 1 {
+		os.Remove(rf.path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.keep))
+		for i := rf.keep - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+		}
+		err = os.Rename(rf.path, rf.path+".1")
+		if err != nil {
+			return err
+		}
+	}
+	return rf.open()
+}
+
+// Close closes the current file.
+func (rf *rotatingFile) Close() error {
+	return rf.f.Close()
+}
@@ -0,0 +1,26 @@
+package main
+
+// Rule is an extra command to run when files matching a glob change, set in the config file:
+//
+//	rules:
+//	  - files: cmd/*
+//	    run: go install ./cmd/...
+type Rule struct {
+	// Files is a glob relative to the module, a directory matches everything below it.
+	Files string `yaml:"files"`
+	Run   string `yaml:"run"`
+
+	// Before runs the command ahead of the build, which only starts once it passes,
+	// e.g. to generate code from a spec. Its files needn't be .go files.
+	Before bool `yaml:"before"`
+}
+
+// matches reports whether any of files (relative to the module) match the rule.
+func (r Rule) matches(files []string) bool {
+	for _, file := range files {
+		if matchEmbed(file, []string{r.Files}) {
+			return true
+		}
+	}
+	return false
+}
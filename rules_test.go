@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRuleMatches(t *testing.T) {
+	tests := []struct {
+		files string
+		batch []string
+		want  bool
+	}{
+		{"cmd/*", []string{"cmd/main.go"}, true},
+		{"cmd/*", []string{"cmd/tool/main.go"}, false},
+		{"cmd", []string{"cmd/tool/main.go"}, true},
+		{"cmd", []string{"cmdline/main.go"}, false},
+		{"docs/*.md", []string{"main.go", "docs/index.md"}, true},
+		{"docs/*.md", []string{"main.go", "docs/index.html"}, false},
+		{"api.yaml", []string{"./api.yaml"}, true},
+		{"cmd/*", nil, false},
+	}
+	for _, test := range tests {
+		rule := Rule{Files: test.files, Run: "true"}
+		if got := rule.matches(test.batch); got != test.want {
+			t.Errorf("Rule{Files: %q}.matches(%q) = %v, want %v", test.files, test.batch, got, test.want)
+		}
+	}
+}
+
+func TestStartRules(t *testing.T) {
+	rules := []Rule{
+		{Files: "cmd", Run: "go install ./cmd/..."},
+		{Files: "docs/*.md", Run: "make docs"},
+		{Files: "api.yaml", Run: "make gen", Before: true},
+		{Files: "cmd/*", Run: "echo cmd"},
+	}
+	builder := &Builder{rules: rules}
+	output := make(chan CommandResult, len(rules))
+	for range rules {
+		builder.ruleCmds = append(builder.ruleCmds, &ReusableCommand{Name: "true", Args: []string{"true"}, Output: output})
+	}
+	defer builder.Stop()
+
+	tests := []struct {
+		batch []string
+		rules []int
+		gens  []int
+	}{
+		{[]string{"main.go"}, nil, nil},
+		{[]string{"cmd/main.go"}, []int{0, 3}, nil},
+		{[]string{"cmd/tool/main.go", "docs/a.md"}, []int{0, 1}, nil},
+		{[]string{"api.yaml"}, nil, []int{2}},
+	}
+	for _, test := range tests {
+		if got := builder.StartRules(test.batch); !reflect.DeepEqual(got, test.rules) {
+			t.Errorf("StartRules(%q) = %v, want %v", test.batch, got, test.rules)
+		}
+		if got := builder.StartGenerators(test.batch); !reflect.DeepEqual(got, test.gens) {
+			t.Errorf("StartGenerators(%q) = %v, want %v", test.batch, got, test.gens)
+		}
+	}
+}
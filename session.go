@@ -0,0 +1,1663 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+// session is a running gowatch, its state is only touched by the event loop in run.
+type session struct {
+	cfg     Config
+	base    Config // cfg without the preset
+	out     io.Writer
+	eout    io.Writer
+	display *Display
+	watcher *fsnotify.Watcher
+	modules []*Module
+	output  chan CommandResult
+	// external are the changed paths printed by the -events-from command.
+	external chan string
+	// failed gets the error that stopped the event loop, Main returns it.
+	failed chan error
+	// refs fires once the git refs have stopped changing for refsSettle, with
+	// -since, and sinceResults gets the changes since the ref worked out again.
+	refs         <-chan time.Time
+	sinceResults chan sinceResult
+	// settled gets the changes back once -settle is up, scoped once git has
+	// scoped them for -git-scope.
+	settled chan change
+	scoped  chan change
+	// versions gets the go versions checked again with -show-go.
+	versions chan versionResult
+	// lists gets the packages listed for the tests to start with.
+	lists chan packageList
+
+	recent recentEvents
+	hashes contentCache
+
+	keys    <-chan byte
+	nextKey chan<- bool
+	// The package picker while it's open, picks gets the packages listed for it.
+	pick  *picker
+	picks chan pickList
+	// prompt is the test name being typed after t, nil when it isn't open.
+	prompt *string
+	// focus is the -run pattern the tests are focused on with t.
+	focus string
+	// paused collects changes instead of building them.
+	paused bool
+	// problemsOnly hides the results that aren't failures or warnings, from
+	// -problems-only and toggled with f, kept when the config is reloaded.
+	problemsOnly bool
+
+	// toggledVerbose is set once v has been used, from then on the verbosity is shown.
+	toggledVerbose bool
+
+	// burstStart and burstCount count the events of the last burstWindow,
+	// burstDone fires once a burst of them has died down.
+	burstStart time.Time
+	burstCount int
+	burstDone  <-chan time.Time
+
+	// roots are the directories whose trees are watched.
+	roots []string
+	// groups are the watch groups from the config file.
+	groups []WatchGroup
+	// onChange runs the -on-change-cmd for every event, nil without one.
+	onChange *changeHook
+
+	// trigger is the absolute path of the -trigger-file, when set only it starts builds.
+	trigger string
+	// file is the absolute path of the -file, when set it's the only file watched.
+	file string
+	// config is the absolute path of the config file, which is read again when it changes.
+	config string
+	// ci streams the results as plain lines instead of showing the dashboard.
+	ci bool
+	// observe only prints what each change would start, with -observe.
+	observe bool
+	// notice is shown above the results until the next build starts, e.g. after reloading the config.
+	notice string
+
+	// The initial build, nil once it is no longer needed.
+	startup <-chan time.Time
+	// idle is set until the first build with -no-initial.
+	idle bool
+	// Fires when a build held back by -min-interval is due.
+	throttle <-chan time.Time
+	// With -full-delay, quick fires once the changes pause long enough to build
+	// and full once they've stopped long enough for everything else.
+	quick <-chan time.Time
+	full  <-chan time.Time
+	// Fires every -interval, nil if there isn't one.
+	interval <-chan time.Time
+	// Fires whenever the -tail is checked for new lines, nil without one.
+	tail <-chan time.Time
+	// Fires whenever the -health-file is due to be written again.
+	health <-chan time.Time
+
+	// Fires every -watch-refresh, nil if there isn't one.
+	refresh <-chan time.Time
+
+	// signals receives the -rebuild-signal and -reload-signal, nil if they're unset.
+	signals       chan os.Signal
+	rebuildSignal os.Signal
+	reloadSignal  os.Signal
+
+	// The overall status of the last finished run, dirty until there has been one.
+	lastStatus Status
+	// notified is when each command was last notified about, for -notify-throttle.
+	// failing are the commands that failed at the last change to bad, by the same keys.
+	notified notifyTimes
+	failing  []string
+	// The line last written to the status file.
+	lastCompact string
+	// The report last written to the -markdown file, apart from its time, the
+	// same for the -html and -junit files and the errors last written to the -pipe.
+	lastMarkdown string
+	lastHTML     string
+	lastJUnit    string
+	lastPipe     string
+	// totals add up the time spent building and testing.
+	totals sessionTotals
+	// state is the last finished results, saved on exit with -restore.
+	state stateKeeper
+	// lastResults are the last results of each command, by directory and name, for -skip-same.
+	lastResults map[string]CommandResult
+
+	// Directories being watched in the background, walking counts the walks still going.
+	added   chan string
+	walked  chan walkResult
+	walking int
+	watched int
+	goFiles int
+
+	// jobs limits how many commands run at once with -max-jobs.
+	jobs chan struct{}
+
+	// metrics counts the results when they're being served.
+	metrics *Metrics
+	// syslog gets the results with -syslog.
+	syslog *sysLog
+	// events tells the -socket clients what's happening.
+	events *Broadcaster
+	// proxy reloads the pages of the -run command after it restarts.
+	proxy *ReloadProxy
+}
+
+// newSession sets up the modules to watch, nothing runs until run is called.
+func newSession(cfg Config, out, eout io.Writer, watcher *fsnotify.Watcher) (*session, error) {
+	s := &session{
+		cfg:     cfg,
+		out:     out,
+		eout:    eout,
+		display: NewDisplay(cfg, out),
+		watcher: watcher,
+		output:  make(chan CommandResult),
+		failed:  make(chan error, 1),
+		recent:  recentEvents{},
+		hashes:  contentCache{},
+
+		problemsOnly: cfg.ProblemsOnly,
+		lastStatus:   StatusDirty,
+		lastResults:  map[string]CommandResult{},
+		notified:     notifyTimes{},
+		added:        make(chan string),
+		walked:       make(chan walkResult),
+		external:     make(chan string),
+		settled:      make(chan change),
+		sinceResults: make(chan sinceResult),
+		scoped:       make(chan change),
+		versions:     make(chan versionResult),
+		lists:        make(chan packageList),
+		picks:        make(chan pickList),
+	}
+
+	dirs := cfg.Modules
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+	if cfg.MaxJobs > 0 {
+		// Shared by every module, so it limits the commands run in total.
+		s.jobs = make(chan struct{}, cfg.MaxJobs)
+	}
+	err := checkNotifyLevel(cfg.NotifyLevel)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Output != "" && len(cfg.Modules) > 1 {
+		return nil, fmt.Errorf("-output can't be used with more than one -module, they'd all build to the same file")
+	}
+	if cfg.CoverProfile != "" && len(cfg.Modules) > 1 {
+		return nil, fmt.Errorf("-coverprofile can't be used with more than one -module, they'd all write the same file")
+	}
+	if cfg.CoverProfile != "" && cfg.PerPackage {
+		return nil, fmt.Errorf("-coverprofile can't be used with -per-package, each package's tests would write the same file")
+	}
+	if cfg.KeepOthers && !cfg.PerPackage {
+		return nil, fmt.Errorf("-keep-unaffected needs -per-package, the tests of all the packages run as one command otherwise")
+	}
+	if cfg.Root != "" && len(cfg.Modules) > 0 {
+		return nil, fmt.Errorf("-root can't be used with -module, each module's commands run in its directory")
+	}
+	if cfg.Since != "" && cfg.GitScope {
+		return nil, fmt.Errorf("-since can't be used with -git-scope, it already takes in the changes not yet committed")
+	}
+	s.onChange = newChangeHook(cfg.OnChange, cfg.Shell)
+	s.groups = append([]WatchGroup(nil), cfg.Groups...)
+	err = setupGroups(s.groups, cfg.Shell, s.output)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		root := dir
+		if cfg.Root != "" {
+			root = cfg.Root
+		}
+		builder, err := NewBuilder(cfg, root, s.output, s.jobs)
+		if err != nil {
+			return nil, err
+		}
+		builder.Lists = s.lists
+		m := &Module{Dir: dir, Root: root}
+		m.setBuilder(builder)
+		if cfg.ShowGo {
+			m.goVersion = goVersion(goCommand, root)
+		}
+		s.modules = append(s.modules, m)
+		s.hashes.addDir(dir)
+		if cfg.Since != "" {
+			// A ref that isn't there should stop gowatch rather than be reported on every change.
+			m.since, err = sinceChanges(root, cfg.Since)
+			if err != nil {
+				return nil, fmt.Errorf("-since %s: %v", cfg.Since, err)
+			}
+			m.edited = map[string]bool{}
+			m.gitDir, err = gitDir(root)
+			if err != nil {
+				return nil, fmt.Errorf("-since %s: %v", cfg.Since, err)
+			}
+		}
+	}
+
+	s.observe = cfg.Observe
+	if cfg.NoInitial || cfg.Observe {
+		s.idle = true
+	} else {
+		s.startup = time.After(cfg.StartupDelay)
+	}
+
+	if cfg.Interval > 0 {
+		s.interval = time.NewTicker(cfg.Interval).C
+	}
+
+	if cfg.Tail > 0 && cfg.RunCmd != "" {
+		s.tail = time.NewTicker(tailRefresh).C
+	}
+
+	if cfg.WatchRefresh > 0 {
+		s.refresh = time.NewTicker(cfg.WatchRefresh).C
+	}
+
+	if cfg.HealthFile != "" {
+		if cfg.HealthAge <= 0 {
+			return nil, fmt.Errorf("-health-max-age must be positive")
+		}
+		// Written from the event loop so it goes stale if the loop gets stuck.
+		s.health = time.NewTicker(cfg.HealthAge / 3).C
+	}
+
+	s.rebuildSignal, err = parseSignal(cfg.RebuildSig)
+	if err != nil {
+		return nil, fmt.Errorf("-rebuild-signal: %v", err)
+	}
+	s.reloadSignal, err = parseSignal(cfg.ReloadSig)
+	if err != nil {
+		return nil, fmt.Errorf("-reload-signal: %v", err)
+	}
+	for _, sig := range []os.Signal{s.rebuildSignal, s.reloadSignal} {
+		if sig != nil {
+			if s.signals == nil {
+				s.signals = make(chan os.Signal, 1)
+			}
+			signal.Notify(s.signals, sig)
+		}
+	}
+
+	config, err := filepath.Abs(cfg.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	s.hashes.changed(config)
+	s.config = config
+
+	if cfg.TriggerFile != "" {
+		trigger, err := filepath.Abs(cfg.TriggerFile)
+		if err != nil {
+			return nil, err
+		}
+		s.trigger = trigger
+	}
+
+	if cfg.File != "" {
+		if len(cfg.Modules) > 0 || cfg.TriggerFile != "" {
+			return nil, fmt.Errorf("-file can't be used with -module or -trigger-file")
+		}
+		s.file, err = filepath.Abs(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// watch starts watching dir and, in the background, every directory below it.
+// The -events-from command reports the changes instead when there is one.
+func (s *session) watch(dir string) error {
+	if s.cfg.EventsFrom != "" {
+		return nil
+	}
+	if s.file != "" {
+		// Its directory rather than the file, editors often replace files when they save them.
+		logger.Info("watching", "file", s.file)
+		return s.watcher.Add(filepath.Dir(s.file))
+	}
+	roots := []string{dir}
+	if len(s.cfg.Watch) > 0 {
+		roots = watchRoots(dir, s.cfg.Watch)
+		if len(roots) == 0 {
+			logger.Info("no -watch directory is in the module", "dir", dir)
+		}
+	}
+	for _, root := range roots {
+		logger.Info("watching", "dir", root)
+		err := s.watcher.Add(root)
+		if err != nil {
+			return err
+		}
+		s.walking++
+		s.roots = append(s.roots, root)
+		maxDepth, _ := treeDepth(s.cfg.MaxDepth, s.roots, root)
+		watchTree(s.watcher, root, maxDepth, s.added, s.walked)
+	}
+	return nil
+}
+
+// watchFiles watches the directories of the config file and the -trigger-file,
+// to see them created, unless they're already watched with a module or group,
+// and the git refs of the modules with -since.
+func (s *session) watchFiles() error {
+	for _, file := range []string{s.config, s.trigger} {
+		if file == "" || s.inTree(filepath.Dir(file)) {
+			continue
+		}
+		err := s.watcher.Add(filepath.Dir(file))
+		if err != nil {
+			return err
+		}
+	}
+	for _, m := range s.modules {
+		if m.gitDir == "" {
+			continue
+		}
+		// HEAD and packed-refs, and the branches, a commit moves one of them.
+		for _, dir := range []string{m.gitDir, filepath.Join(m.gitDir, "refs", "heads")} {
+			err := s.watcher.Add(dir)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// inTree reports whether the absolute dir is watched as part of the trees below
+// the roots, not too deep for -max-depth nor ignored.
+func (s *session) inTree(dir string) bool {
+	if ignored(dir, s.cfg.Ignore) {
+		return false
+	}
+	for _, root := range s.roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(abs, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if _, ok := treeDepth(s.cfg.MaxDepth, []string{abs}, dir); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// run is the event loop, it only returns once it has sent an error it can't
+// carry on after to failed.
+func (s *session) run() {
+	if s.cfg.Warm {
+		for _, m := range s.modules {
+			m.Builder.Warm()
+		}
+	}
+	if s.observe {
+		fmt.Fprintln(s.out, "observing, nothing is built: each change is shown with what it would start")
+	}
+	if s.idle || restoredBanner(s.modules) != "" {
+		s.render()
+	}
+	if s.health != nil {
+		s.writeHealth()
+	}
+	for {
+		redraw := true
+		select {
+		case ev, ok := <-s.watcher.Events:
+			if !ok && !s.watcherClosed() {
+				return
+			} else if !ok {
+				break
+			}
+			// With -skip-same the results stay as they are until one of them changes.
+			redraw = s.handleEvent(ev) && !s.cfg.SkipSame
+		case path := <-s.external:
+			redraw = s.handleEvent(fsnotify.Event{Name: path, Op: fsnotify.Write}) && !s.cfg.SkipSame
+		case v := <-s.versions:
+			// Dropped if -show-go was turned off since.
+			if s.cfg.ShowGo {
+				v.m.goVersion = v.version
+			}
+		case <-s.refs:
+			s.refs = nil
+			s.refreshSince()
+		case l := <-s.picks:
+			// Unless it was cancelled while they were being listed.
+			redraw = l.p == s.pick
+			if redraw && l.err != nil {
+				fmt.Fprintln(s.eout, "error:", l.err)
+				s.pick = nil
+			} else if redraw {
+				s.pick.fill(s.modules, l.pkgs)
+			}
+		case l := <-s.lists:
+			for _, m := range s.modules {
+				// Not if the builder was replaced by a reload since.
+				if m.Builder == l.builder {
+					l.builder.PackagesListed(l)
+				}
+			}
+		case r := <-s.sinceResults:
+			redraw = s.sinceRefreshed(r) && !s.cfg.SkipSame
+		case c := <-s.settled:
+			redraw = s.changeSettled(c) && !s.cfg.SkipSame
+		case c := <-s.scoped:
+			if c.err != nil {
+				fmt.Fprintln(s.eout, "error:", c.err)
+			}
+			if c.affected {
+				redraw = s.depsListed(c) && !s.cfg.SkipSame
+				break
+			}
+			redraw = s.startChange(c) && !s.cfg.SkipSame
+		case <-s.burstDone:
+			s.burstOver()
+		case <-s.throttle:
+			s.throttle = nil
+			s.startThrottled()
+		case <-s.quick:
+			s.quick = nil
+			for _, m := range s.modules {
+				if m.pending != nil && !m.pending.onlyTests && m.generating == nil {
+					logger.Debug("building ahead of the rest", "dir", m.Dir)
+					s.idle = false
+					m.startBuild(*m.pending)
+				}
+			}
+		case <-s.full:
+			s.full = nil
+			for _, m := range s.modules {
+				if m.pending == nil {
+					continue
+				}
+				t := *m.pending
+				// Nothing has changed since the quick build, it needn't be built again.
+				t.built = !t.onlyTests && m.Builder.QuickPassed()
+				s.start(m, t)
+				if t.built && m.generating == nil {
+					s.builtAhead(m)
+				}
+			}
+		case <-s.startup:
+			s.startup = nil
+			for _, m := range s.modules {
+				s.start(m, trigger{packages: m.sincePackages()})
+			}
+		case <-s.tail:
+			redraw = tailChanged(s.modules)
+		case <-s.interval:
+			if s.paused {
+				break
+			}
+			logger.Debug("rebuilding on the interval")
+			for _, m := range s.modules {
+				s.start(m, trigger{})
+			}
+			redraw = !s.cfg.SkipSame
+		case <-s.health:
+			s.writeHealth()
+			redraw = false
+		case <-s.refresh:
+			s.refreshWatcher()
+		case sig := <-s.signals:
+			switch sig {
+			case s.rebuildSignal:
+				logger.Info("rebuilding on a signal", "signal", sig)
+				for _, m := range s.modules {
+					s.start(m, trigger{})
+				}
+			case s.reloadSignal:
+				s.reloadConfig()
+			}
+		case key := <-s.keys:
+			s.handleKey(key)
+			s.nextKey <- true
+		case dir := <-s.added:
+			s.watched++
+			logger.Debug("watching", "dir", dir)
+			if m := moduleFor(dir, s.modules); m != nil {
+				m.findEmbeds(s.cfg, s.watcher, dir)
+			}
+			redraw = s.watched%100 == 0
+		case walk := <-s.walked:
+			s.walking--
+			s.goFiles += walk.goFiles
+			if walk.err != nil {
+				logger.Error("watching directories", "err", walk.err)
+				fmt.Fprintln(s.eout, "error:", walk.err)
+			}
+			if s.walking == 0 {
+				logger.Info("watching everything", "dirs", s.watched, "go_files", s.goFiles)
+			}
+		case err, ok := <-s.watcher.Errors:
+			if !ok && !s.watcherClosed() {
+				return
+			} else if !ok {
+				break
+			}
+			logger.Error("watcher error", "err", err)
+			fmt.Fprintln(s.eout, "error:", err)
+		case op := <-s.output:
+			if s.groupFinished(op) {
+				break
+			}
+			if s.cfg.TAP {
+				printTAP(s.out, op)
+			} else if s.ci {
+				printCI(s.out, op, len(s.modules) > 1)
+			}
+			if op.Status == StatusKilled {
+				// Only shown, the command it was restarted as is what counts.
+				moduleByDir(op.Dir, s.modules).Killed = op
+				break
+			}
+			same := s.sameResult(op)
+			s.remember(&op)
+			m := moduleByDir(op.Dir, s.modules)
+			was, overallWas := m.status(), overallStatus(s.modules)
+			s.handleResult(op)
+			// Even an unchanged result can settle its module, or everything.
+			redraw = !same || !s.cfg.SkipSame || m.status() != was || overallStatus(s.modules) != overallWas
+		}
+
+		if redraw {
+			s.render()
+		}
+	}
+}
+
+// refreshWatcher replaces the watcher with a new one watching everything again,
+// in case the old one stopped getting events, and rebuilds as changes may have been missed.
+func (s *session) refreshWatcher() {
+	if s.walking > 0 {
+		logger.Info("not refreshing the watcher while directories are still being added")
+		return
+	}
+	s.replaceWatcher()
+}
+
+// watcherClosed replaces the watcher if it has stopped by itself and -keep-alive
+// is on, without it there's no way to carry on so it reports whether to.
+func (s *session) watcherClosed() bool {
+	if !s.cfg.KeepAlive {
+		s.failed <- fmt.Errorf("the file watcher stopped")
+		return false
+	}
+	logger.Error("the file watcher stopped, replacing it")
+	fmt.Fprintln(s.eout, "error: the file watcher stopped, replacing it")
+	if !s.replaceWatcher() {
+		// Don't spin on the closed one while a new one can't be made.
+		time.Sleep(time.Second)
+	}
+	return true
+}
+
+// replaceWatcher does the work of refreshWatcher, reporting whether there's a new watcher.
+func (s *session) replaceWatcher() bool {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Error("refreshing the watcher", "err", err)
+		fmt.Fprintln(s.eout, "error: refreshing the watcher:", err)
+		return false
+	}
+
+	logger.Info("refreshing the watcher")
+	// The old one is only closed once the new one is watching, and the build
+	// afterwards covers any changes in between.
+	old := s.watcher
+	s.watcher = watcher
+	s.roots = nil
+	s.watched = 0
+	s.goFiles = 0
+	for _, m := range s.modules {
+		err = s.watch(m.Dir)
+		if err != nil {
+			logger.Error("refreshing the watcher", "dir", m.Dir, "err", err)
+			fmt.Fprintln(s.eout, "error: refreshing the watcher:", err)
+		}
+	}
+	err = s.watchGroups()
+	if err != nil {
+		logger.Error("refreshing the watcher", "err", err)
+		fmt.Fprintln(s.eout, "error: refreshing the watcher:", err)
+	}
+	err = s.watchFiles()
+	if err != nil {
+		logger.Error("refreshing the watcher", "err", err)
+	}
+	old.Close()
+	for _, m := range s.modules {
+		s.schedule(m, trigger{})
+	}
+	return true
+}
+
+// writeHealth updates the -health-file, reporting but otherwise ignoring failures.
+func (s *session) writeHealth() {
+	err := writeHealth(s.cfg.HealthFile)
+	if err != nil {
+		logger.Error("writing the health file", "err", err)
+		fmt.Fprintln(s.eout, "error: health file:", err)
+	}
+}
+
+// sameResult reports whether op is identical to the last result of its command.
+func (s *session) sameResult(op CommandResult) bool {
+	last, found := s.lastResults[op.Dir+"\x00"+op.Name]
+	return found && last.Status == op.Status && last.Exit == op.Exit && last.Output == op.Output
+}
+
+// remember keeps op as the last result of its command, first noting the status
+// it had before for -show-change and the changes that broke it for -blame.
+func (s *session) remember(op *CommandResult) {
+	key := op.Dir + "\x00" + op.Name
+	last, found := s.lastResults[key]
+	if found && s.cfg.ShowChange && last.Status != op.Status {
+		op.Was = last.Status
+	}
+	if found && s.cfg.Blame && last.Status == StatusOk && op.Status == StatusBad {
+		op.Blame = moduleByDir(op.Dir, s.modules).changed
+	}
+	s.lastResults[key] = *op
+}
+
+// handleEvent starts whatever a file event needs, returning false if it was ignored.
+func (s *session) handleEvent(ev fsnotify.Event) bool {
+	logger.Debug("file event", "name", ev.Name, "op", ev.Op)
+	if s.refsChanged(ev.Name) {
+		return false
+	}
+	if s.onChange != nil {
+		s.onChange.run(ev.Name)
+	}
+	if ev.Op&fsnotify.Create != 0 && s.file == "" {
+		if info, err := os.Stat(ev.Name); err == nil && info.IsDir() && !ignored(ev.Name, s.cfg.Ignore) {
+			if maxDepth, ok := treeDepth(s.cfg.MaxDepth, s.roots, ev.Name); ok {
+				s.walking++
+				watchTree(s.watcher, ev.Name, maxDepth, s.added, s.walked)
+			}
+			if m := moduleFor(ev.Name, s.modules); m != nil {
+				// It may be a new package.
+				m.Builder.ForgetPackages()
+			}
+			return false
+		}
+	}
+	if abs, _ := filepath.Abs(ev.Name); abs == s.config {
+		if s.recent.seen(ev.Name) || !s.hashes.changed(ev.Name) {
+			return false
+		}
+		s.reloadConfig()
+		return true
+	}
+	if s.trigger != "" {
+		return s.handleTrigger(ev)
+	}
+	if s.file != "" {
+		return s.handleFile(ev)
+	}
+	if ignored(ev.Name, s.cfg.Ignore) {
+		return s.ignore(ev.Name, "matches -ignore")
+	}
+	if !s.observe && s.burst() {
+		return false
+	}
+	if s.recent.seen(ev.Name) {
+		return s.ignore(ev.Name, "part of the same save as the event before")
+	}
+	if g := groupFor(ev.Name, s.groups); g != nil {
+		return s.groupChanged(g, ev.Name)
+	}
+	m := moduleFor(ev.Name, s.modules)
+	if m == nil {
+		return s.ignore(ev.Name, "outside the modules")
+	}
+
+	var t trigger
+	if rel, err := filepath.Rel(m.Dir, ev.Name); err == nil {
+		t.files = []string{rel}
+	}
+	if why := unwatched(s.cfg, []string{m.Dir}, ev.Name, m.embedded(ev.Name) || m.Builder.Generates(t.files)); why != "" {
+		return s.ignore(ev.Name, why)
+	}
+	// why explains what the change starts for -observe.
+	var why string
+	// gitScope is set when git has to scope the change first, affected when
+	// the packages depending on the file's do for -affected.
+	var gitScope, affected bool
+	if isModFile(ev.Name) {
+		why = "module file, downloads the modules and rebuilds everything"
+		t.modules = true
+		// The packages depended on may be different now, and those there are.
+		m.deps = nil
+		m.Builder.ForgetPackages()
+		if s.cfg.ShowGo {
+			// And so may the toolchain.
+			s.checkGoVersion(m)
+		}
+	} else if m.embedded(ev.Name) {
+		// Embedded files only change the build, which go test rebuilds anyway.
+		why = "embedded by //go:embed, builds and tests"
+	} else if inTestdata(ev.Name) {
+		// Test fixtures, of any kind, only matter to the tests. The go tool
+		// ignores testdata directories, even .go files in them aren't built.
+		why = "in testdata, only tests"
+		t.onlyTests = true
+	} else if inTestOnly(m.Dir, ev.Name, s.cfg.TestOnly) {
+		// Like testdata, but wherever the tests load their files from.
+		why = "matches -test-only, only tests"
+		t.onlyTests = true
+	} else if m.Builder.Generates(t.files) {
+		// A spec that code is generated from, the build follows the generator.
+		why = "a rule runs before the build, which follows it"
+	} else if strings.HasSuffix(ev.Name, ".go") {
+		// The //go:embed directives may have changed.
+		m.findEmbeds(s.cfg, s.watcher, filepath.Dir(ev.Name))
+
+		// go build doesn't compile tests, so test file changes only need a test run.
+		t.onlyTests = strings.HasSuffix(ev.Name, "_test.go")
+		why = "go file, builds and tests"
+		if t.onlyTests {
+			why = "test file, only tests"
+		}
+
+		if s.cfg.Incremental {
+			abs, _ := filepath.Abs(ev.Name)
+			t.vetPackages = packagesOf(m.Root, map[string]bool{abs: true})
+		}
+
+		affected = s.cfg.Affected
+
+		if s.cfg.Focus != "" {
+			active, err := readFocus(s.cfg.Focus)
+			if err != nil {
+				fmt.Fprintln(s.eout, "error:", err)
+			} else if len(active) > 0 {
+				abs, _ := filepath.Abs(ev.Name)
+				if !active[abs] {
+					return s.ignore(ev.Name, "outside the -focus")
+				}
+				t.packages = packagesOf(m.Root, active)
+				affected = false
+			}
+		}
+
+		// Scoped by git instead.
+		gitScope = s.cfg.GitScope
+		affected = affected && !gitScope
+
+		if m.since != nil {
+			// Edited since, even if it's back the way it was at the ref.
+			abs, _ := filepath.Abs(ev.Name)
+			m.edited[abs] = true
+			t.packages = m.sincePackages()
+			affected = false
+		}
+	} else {
+		// Asked for with -include, it may matter to anything.
+		why = "matches -include, builds and tests"
+	}
+
+	c := change{m: m, name: ev.Name, why: why, t: t, gitScope: gitScope, affected: affected}
+	if s.cfg.Settle > 0 {
+		// Some editors are still writing the file when the event arrives, it
+		// would be built half written. The loop carries on in the meantime.
+		time.AfterFunc(s.cfg.Settle, func() {
+			s.settled <- c
+		})
+		return false
+	}
+	return s.changeSettled(c)
+}
+
+// change is a file event on its way to starting t on m, why is what it starts
+// for -observe, gitScope whether git has to scope it first, affected whether
+// the dependency graph does and err what went wrong scoping it, if anything
+// did. deps is the graph listed again for it.
+type change struct {
+	m        *Module
+	name     string
+	why      string
+	t        trigger
+	gitScope bool
+	affected bool
+	deps     *depGraph
+	err      error
+}
+
+// changeSettled carries on with c once the file has been written, unless it's
+// the same as it was.
+func (s *session) changeSettled(c change) bool {
+	if !s.hashes.changed(c.name) {
+		return s.ignore(c.name, "contents unchanged")
+	}
+	if c.gitScope {
+		// git can take a while in a big repository, it doesn't hold up the loop.
+		go s.gitScoped(c)
+		return false
+	}
+	if c.affected {
+		abs, _ := filepath.Abs(c.name)
+		if !c.m.knowsDeps(abs) {
+			// As can go list.
+			go s.listDeps(c)
+			return false
+		}
+		if pkgs := c.m.affectedPackages(abs); len(pkgs) > 0 {
+			c.t.packages = pkgs
+		}
+	}
+	return s.startChange(c)
+}
+
+// listDeps lists the dependency graph of c's module again for -affected, in the
+// background, sending c back to the loop with it to be scoped by it.
+func (s *session) listDeps(c change) {
+	logger.Debug("listing the packages", "dir", c.m.Root)
+	c.deps, c.err = loadDepGraph(c.m.Root)
+	s.scoped <- c
+}
+
+// gitScoped scopes c to the packages with changes not yet committed, and that
+// of the file changed even if it's back the way it was committed, for
+// -git-scope. It runs git, so it's run in the background and sends c back to
+// the loop, unscoped if git failed.
+func (s *session) gitScoped(c change) {
+	changed, err := gitChanges(c.m.Root)
+	if err != nil {
+		c.err = err
+	} else {
+		abs, _ := filepath.Abs(c.name)
+		changed[abs] = true
+		c.t.packages = packagesOf(c.m.Root, changed)
+	}
+	s.scoped <- c
+}
+
+// depsListed scopes c by the dependency graph listed for it, keeping the graph
+// for the changes after it, or to just the file's own package if listing failed.
+func (s *session) depsListed(c change) bool {
+	c.m.deps = c.deps
+	abs, _ := filepath.Abs(c.name)
+	if pkgs := c.m.affectedPackages(abs); len(pkgs) > 0 {
+		c.t.packages = pkgs
+	}
+	return s.startChange(c)
+}
+
+// startChange starts what c needs, or with -observe only prints what it would have.
+func (s *session) startChange(c change) bool {
+	if s.observe {
+		s.observed(c.m, c.name, c.why, c.t)
+		return false
+	}
+	s.startup = nil
+	if s.events != nil {
+		s.events.Send(socketEvent{Event: "trigger", Dir: c.m.Dir, File: c.name})
+	}
+	return s.schedule(c.m, c.t)
+}
+
+// refsChanged reports whether path is in the git directory of a module with
+// -since, which is only watched for its refs. A change to them, a commit or
+// checkout, works out the changes since the ref again once they pause.
+func (s *session) refsChanged(path string) bool {
+	for _, m := range s.modules {
+		if m.gitDir == "" || !inGitDir(m.gitDir, path) {
+			continue
+		}
+		if isRefChange(m.gitDir, path) && s.refs == nil {
+			s.refs = time.After(refsSettle)
+		}
+		return true
+	}
+	return false
+}
+
+// refreshSince works out the changes since the -since ref of every module
+// again, in the background as git can take a while, sending them to sinceResults.
+func (s *session) refreshSince() {
+	for _, m := range s.modules {
+		if m.since == nil {
+			continue
+		}
+		go func(m *Module, ref string) {
+			changed, err := sinceChanges(m.Root, ref)
+			s.sinceResults <- sinceResult{m, changed, err}
+		}(m, s.cfg.Since)
+	}
+}
+
+// sinceResult are the changes since the -since ref worked out again for m.
+type sinceResult struct {
+	m       *Module
+	changed map[string]bool
+	err     error
+}
+
+// sinceRefreshed takes in the changes since the ref worked out again, building
+// the packages they now cover if they're different.
+func (s *session) sinceRefreshed(r sinceResult) bool {
+	if r.err != nil {
+		fmt.Fprintln(s.eout, "error: -since:", r.err)
+		return true
+	}
+	m := r.m
+	if m.since == nil {
+		// -since was turned off since.
+		return false
+	}
+	before := strings.Join(m.sincePackages(), " ")
+	m.since = r.changed
+	after := m.sincePackages()
+	if strings.Join(after, " ") == before {
+		return false
+	}
+	logger.Info("the packages changed since the ref are different", "dir", m.Dir, "ref", s.cfg.Since, "packages", after)
+	return s.schedule(m, trigger{packages: after})
+}
+
+// ignore reports that name doesn't start anything and why, printed with -observe.
+// It always returns false, as there's nothing new to show.
+func (s *session) ignore(name, why string) bool {
+	logger.Debug("ignoring file", "name", name, "why", why)
+	if s.observe {
+		fmt.Fprintf(s.out, "ignored      %s: %s\n", name, why)
+	}
+	return false
+}
+
+// observed prints what t would have started on m for the change to name, with -observe.
+func (s *session) observed(m *Module, name, why string, t trigger) {
+	var rules []string
+	for _, rule := range m.Builder.rules {
+		if rule.matches(t.files) {
+			rules = append(rules, rule.Run)
+		}
+	}
+	if len(rules) > 0 {
+		why += ", rules: " + strings.Join(rules, ", ")
+	}
+	if t.packages != nil {
+		why += ", packages: " + strings.Join(t.packages, " ")
+	}
+	fmt.Fprintf(s.out, "would start  %s: %s\n", name, why)
+}
+
+// burst counts ev towards a burst, like a git checkout touching thousands of
+// files, and reports whether it's part of one. Instead of reacting to each of
+// their events, everything is rebuilt once the burst is over.
+func (s *session) burst() bool {
+	now := time.Now()
+	if s.burstDone == nil && now.Sub(s.burstStart) > burstWindow {
+		s.burstStart = now
+		s.burstCount = 0
+	}
+	s.burstCount++
+	if s.burstCount < burstEvents {
+		return false
+	}
+	if s.burstCount == burstEvents {
+		logger.Info("collapsing a burst of file events into one rebuild", "events", burstEvents, "window", burstWindow)
+	}
+	s.burstDone = time.After(burstWindow)
+	return true
+}
+
+// burstOver rebuilds everything after a burst of events.
+func (s *session) burstOver() {
+	logger.Info("burst of file events is over, rebuilding", "events", s.burstCount)
+	s.burstDone = nil
+	s.burstCount = 0
+	s.startup = nil
+	for _, m := range s.modules {
+		s.schedule(m, trigger{modules: true})
+	}
+}
+
+// handleTrigger starts a full build of every module when ev touched the
+// -trigger-file, everything else is ignored. Any event counts since touching
+// the file only changes its times.
+func (s *session) handleTrigger(ev fsnotify.Event) bool {
+	abs, _ := filepath.Abs(ev.Name)
+	if abs != s.trigger {
+		logger.Debug("ignoring file other than the trigger file", "name", ev.Name)
+		return false
+	}
+	if s.recent.seen(ev.Name) {
+		logger.Debug("coalescing event from the same save", "name", ev.Name)
+		return false
+	}
+	s.startup = nil
+	for _, m := range s.modules {
+		if s.events != nil {
+			s.events.Send(socketEvent{Event: "trigger", Dir: m.Dir, File: ev.Name})
+		}
+		s.schedule(m, trigger{})
+	}
+	return true
+}
+
+// handleFile rebuilds when the -file changes, whatever kind of file it is.
+func (s *session) handleFile(ev fsnotify.Event) bool {
+	abs, _ := filepath.Abs(ev.Name)
+	if abs != s.file {
+		logger.Debug("ignoring file other than the -file", "name", ev.Name)
+		return false
+	}
+	if s.recent.seen(ev.Name) {
+		logger.Debug("coalescing event from the same save", "name", ev.Name)
+		return false
+	}
+	if !s.hashes.changed(ev.Name) {
+		logger.Debug("ignoring unchanged file", "name", ev.Name)
+		return false
+	}
+	m := s.modules[0]
+	if s.events != nil {
+		s.events.Send(socketEvent{Event: "trigger", Dir: m.Dir, File: ev.Name})
+	}
+	var t trigger
+	if rel, err := filepath.Rel(m.Dir, ev.Name); err == nil {
+		t.files = []string{rel}
+	}
+	return s.schedule(m, t)
+}
+
+// schedule starts t on m, unless it has to wait until watching resumes or for the -min-interval.
+func (s *session) schedule(m *Module, t trigger) bool {
+	if s.paused {
+		logger.Debug("paused, holding the build back", "dir", m.Dir)
+		m.queue(t)
+		return true
+	}
+
+	if s.cfg.FullDelay > 0 {
+		// Every change puts both tiers back, and neither starts before -min-interval is up.
+		m.queue(t)
+		quick, full := s.cfg.QuickDelay, s.cfg.FullDelay
+		if wait := s.cfg.MinInterval - time.Since(m.started); wait > quick {
+			logger.Debug("throttling build", "dir", m.Dir, "wait", wait)
+			quick = wait
+			if wait > full {
+				full = wait
+			}
+		}
+		s.quick = time.After(quick)
+		s.full = time.After(full)
+		return true
+	}
+
+	if wait := s.cfg.MinInterval - time.Since(m.started); wait > 0 {
+		logger.Debug("throttling build", "dir", m.Dir, "wait", wait)
+		m.queue(t)
+		if s.throttle == nil {
+			s.throttle = time.After(wait)
+		}
+		return true
+	}
+	s.start(m, t)
+	return true
+}
+
+// start starts t on m, telling the -socket clients.
+func (s *session) start(m *Module, t trigger) {
+	if s.observe {
+		return
+	}
+	if m.generating != nil {
+		logger.Debug("generating, holding the build back", "dir", m.Dir)
+		m.queue(t)
+		return
+	}
+	s.idle = false
+	s.notice = ""
+	if s.events != nil {
+		s.events.Send(socketEvent{Event: "start", Dir: m.Dir})
+	}
+	m.start(t, s.cfg.ModDownload)
+}
+
+// startThrottled starts the builds held back by -min-interval that are now due.
+func (s *session) startThrottled() {
+	if s.paused {
+		// They'll all start on resume.
+		return
+	}
+
+	var next time.Duration
+	for _, m := range s.modules {
+		if m.pending == nil {
+			continue
+		}
+		wait := s.cfg.MinInterval - time.Since(m.started)
+		if wait <= 0 {
+			s.start(m, *m.pending)
+			continue
+		}
+		if next == 0 || wait < next {
+			next = wait
+		}
+	}
+	if next > 0 {
+		s.throttle = time.After(next)
+	}
+}
+
+// handleKey acts on a key pressed in the terminal.
+func (s *session) handleKey(key byte) {
+	if s.pick != nil {
+		if !s.pick.key(key) {
+			return
+		}
+		if !s.pick.cancelled {
+			s.pick.apply(s.modules)
+			for _, m := range s.modules {
+				s.start(m, trigger{})
+			}
+		}
+		s.pick = nil
+		return
+	}
+
+	if s.prompt != nil {
+		s.promptKey(key)
+		return
+	}
+
+	switch key {
+	case 't':
+		text := s.focus
+		s.prompt = &text
+	case 'p':
+		s.paused = !s.paused
+		if s.paused {
+			return
+		}
+		// Everything that changed while paused gets a single build.
+		for _, m := range s.modules {
+			if m.pending != nil {
+				s.start(m, *m.pending)
+			}
+		}
+	case 'v':
+		for _, m := range s.modules {
+			if m.Builder.ToggleVerbose() {
+				s.toggledVerbose = true
+				s.start(m, trigger{onlyTests: true})
+			}
+		}
+	case 'n':
+		names := presetNames(s.base)
+		if len(names) == 0 {
+			return
+		}
+		next := names[0]
+		for i, name := range names {
+			if name == s.cfg.Preset && i+1 < len(names) {
+				next = names[i+1]
+			}
+		}
+		err := s.usePreset(next)
+		if err != nil {
+			fmt.Fprintln(s.eout, "error:", err)
+		}
+	case 'r':
+		for _, m := range s.modules {
+			if m.rerunFailure() {
+				return
+			}
+		}
+	case 'e':
+		path := s.cfg.HTML
+		if path == "" {
+			path = defaultReport
+		}
+		report, err := htmlReport(reportResults(s.modules))
+		if err == nil {
+			err = exportHTML(report, path)
+		}
+		if err != nil {
+			fmt.Fprintln(s.eout, "error:", err)
+			return
+		}
+		if path == s.cfg.HTML {
+			s.lastHTML = report
+		}
+		s.notice = "report written to " + path
+	case 'c':
+		err := openCoverage(s.cfg.CoverProfile)
+		if err != nil {
+			fmt.Fprintln(s.eout, "error:", err)
+		}
+	case 'l':
+		err := pageFailure(reportResults(s.modules))
+		if err != nil {
+			fmt.Fprintln(s.eout, "error:", err)
+			return
+		}
+		// The pager may have left anything on the screen.
+		s.display.Forget()
+	case 'f':
+		s.problemsOnly = !s.problemsOnly
+		s.display.ProblemsOnly = s.problemsOnly
+	case 'x':
+		for _, m := range s.modules {
+			m.testSkipped()
+		}
+	case 'o':
+		err := openError(reportResults(s.modules), s.cfg.EditorCmd)
+		if err != nil {
+			fmt.Fprintln(s.eout, "error:", err)
+			return
+		}
+		// So may the editor.
+		s.display.Forget()
+	case 's':
+		s.pick = newPicker(s.modules, s.picks)
+	}
+}
+
+// promptKey handles a key typed at the prompt opened with t, focusing the tests
+// on what was typed once enter is pressed.
+func (s *session) promptKey(key byte) {
+	text := *s.prompt
+	switch {
+	case key == 27:
+		s.prompt = nil
+	case key == 127 || key == '\b':
+		if text != "" {
+			text = text[:len(text)-1]
+		}
+		s.prompt = &text
+	case key == '\n' || key == '\r':
+		s.prompt = nil
+		s.focus = text
+		for _, m := range s.modules {
+			if m.Builder.FocusTests(text) {
+				s.start(m, trigger{onlyTests: true})
+			}
+		}
+	case key >= ' ' && key < 127:
+		text += string(key)
+		s.prompt = &text
+	}
+}
+
+// handleResult records a finished command on its module.
+func (s *session) handleResult(op CommandResult) {
+	if s.metrics != nil {
+		s.metrics.Record(op)
+	}
+	if s.syslog != nil {
+		s.syslog.Record(op)
+	}
+	if s.events != nil {
+		s.events.Send(socketEvent{Event: "result", Dir: op.Dir, Name: op.Name, Status: op.Status.String(), Errors: op.Errors})
+	}
+
+	m := moduleByDir(op.Dir, s.modules)
+	defer m.syncPackages()
+	if m.Builder.isPackageTest(op.Name) {
+		combined, done := m.packageFinished(op)
+		if !done {
+			return
+		}
+		op = combined
+	}
+	switch op.Name {
+	case m.Builder.testCmd.Name:
+		if m.Builder.TestsFinished(op) {
+			// The earlier failures pass, wait for the full suite.
+			return
+		}
+		s.totals.addTests(op.Duration, op.Status, op.Tests.Failures)
+		m.sameFailures = len(op.Tests.Failures) > 0 && sameFailures(m.Test.Tests.Failures, op.Tests.Failures)
+		m.Test = op
+		if op.Status == StatusOk {
+			err := m.Builder.SaveCoverage()
+			if err != nil {
+				fmt.Fprintln(s.eout, "error: coverage profile:", err)
+			}
+		}
+	case m.Builder.exampleCmd.Name:
+		m.Examples = op
+	case m.Builder.buildCmd.Name:
+		m.restored = time.Time{}
+		if m.Builder.binDir != "" && op.Status == StatusOk {
+			m.measure(&op)
+		}
+		if op.Status == StatusOk && m.Builder.StartVet() {
+			// The build isn't finished until vet has passed too.
+			m.built = op
+			return
+		}
+		s.buildFinished(m, op)
+	case m.Builder.vetCmd.Name:
+		build := m.built
+		build.Duration += op.Duration
+		if op.Status == StatusBad {
+			build.Status = StatusBad
+			build.Output += op.Output
+			build.Errors = append(build.Errors, op.Errors...)
+			build.Exit = "vet: " + op.Exit
+		}
+		s.buildFinished(m, build)
+	case m.Builder.rerunCmd.Name:
+		// Keep the name of the test being rerun.
+		op.Name = m.Rerun.Name
+		m.Rerun = op
+	case m.Builder.runCmd.Name:
+		m.Run = op
+	case m.Builder.tidyCmd.Name:
+		m.Tidy = op
+	case m.Builder.modCmd.Name:
+		if op.Status == StatusOk {
+			m.Builder.Start()
+			return
+		}
+		// Show the failed download in place of the build that never ran.
+		m.Build = op
+		if m.Tidy.Status == StatusDirty {
+			m.Tidy = CommandResult{Name: m.Builder.tidyCmd.Name, Dir: m.Dir, Status: StatusBad, Exit: "not run", Output: "not run, downloading the modules failed\n"}
+		}
+	default:
+		if i := m.Builder.targetIndex(op.Name); i >= 0 {
+			m.Targets[i] = op
+		}
+		if i := m.Builder.ruleIndex(op.Name); i >= 0 {
+			m.Rules[i] = op
+			if m.Builder.rules[i].Before {
+				if t := m.generatorsDone(); t != nil {
+					s.start(m, *t)
+				}
+			}
+		}
+	}
+}
+
+// buildFinished shows the finished build, and carries on with the tests and run command waiting for it.
+func (s *session) buildFinished(m *Module, build CommandResult) {
+	m.Build = build
+	s.totals.addBuild(build.Duration, build.Status)
+	if m.Builder.BuildFinished(build.Status) {
+		m.skipTests()
+	}
+	if build.Status == StatusOk {
+		s.startRun(m)
+	}
+}
+
+// builtAhead finishes the build the quick tier of -full-delay started on m,
+// once the rest has started too: vetting it with -strict, then restarting the
+// run command, which the quick build left out.
+func (s *session) builtAhead(m *Module) {
+	if m.Builder.StartVet() {
+		m.built = m.Build
+		m.Build.Status = StatusDirty
+		return
+	}
+	s.startRun(m)
+}
+
+// startRun restarts the run command of m after a build passed, if there is one.
+func (s *session) startRun(m *Module) {
+	if m.Builder.StartRun() {
+		m.Run = CommandResult{Name: m.Builder.runCmd.Name, Dir: m.Root, Status: StatusDirty}
+		if s.proxy != nil {
+			s.proxy.ReloadWhenUp()
+		}
+	}
+}
+
+// usePreset switches to the preset called name, restarting everything with its commands.
+func (s *session) usePreset(name string) error {
+	logger.Info("switching preset", "preset", name)
+	return s.reconfigure(s.base, name)
+}
+
+// reloadConfig reads the config file again and restarts everything with the
+// new options, keeping the old ones if they aren't valid.
+func (s *session) reloadConfig() {
+	fs := flag.NewFlagSet("gowatch", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	base, err := loadConfig(fs, s.base.args)
+	if err == nil {
+		logger.Info("reloading the config file", "file", s.cfg.ConfigFile)
+		err = s.reconfigure(base, s.cfg.Preset)
+	}
+	if err != nil {
+		logger.Error("reloading the config file", "err", err)
+		s.notice = "config not reloaded: " + err.Error()
+		return
+	}
+	s.notice = "config reloaded"
+}
+
+// reconfigure switches to the options of base with the preset called name on
+// top, restarting everything with their commands.
+func (s *session) reconfigure(base Config, name string) error {
+	cfg, err := applyPreset(base, name)
+	if err != nil {
+		return err
+	}
+	if s.ci {
+		cfg = plainConfig(cfg)
+	}
+	builders := make([]*Builder, len(s.modules))
+	for i, m := range s.modules {
+		builders[i], err = NewBuilder(cfg, m.Root, s.output, s.jobs)
+		if err != nil {
+			return err
+		}
+		builders[i].Lists = s.lists
+	}
+	// Only once the options are known to be valid, the old colors stay otherwise.
+	err = setColors(cfg.Colors)
+	if err != nil {
+		return err
+	}
+	err = setThemes(cfg.Themes)
+	if err != nil {
+		return err
+	}
+	err = setLinks(cfg.Links, cfg.LinkFormat, s.out)
+	if err != nil {
+		return err
+	}
+
+	since := s.cfg.Since
+	s.base = base
+	s.cfg = cfg
+	setRunningMessages(cfg.Running)
+	setLabels(cfg.Labels, cfg.Tags)
+	goCommand = cfg.Go
+	s.display.Configure(cfg)
+	s.display.ProblemsOnly = s.problemsOnly
+	s.onChange = newChangeHook(cfg.OnChange, cfg.Shell)
+	for i, m := range s.modules {
+		m.Builder.Stop()
+		m.setBuilder(builders[i])
+		m.Builder.FocusTests(s.focus)
+		m.goVersion = ""
+		if cfg.ShowGo {
+			s.checkGoVersion(m)
+		}
+		s.resetSince(m, since)
+		s.start(m, trigger{packages: m.sincePackages()})
+	}
+	if cfg.Since != "" && cfg.Since != since {
+		s.refreshSince()
+	}
+	return nil
+}
+
+// resetSince turns -since off for m after a reload without it, or on, building
+// nothing until the changes since the new ref are worked out. since was the old ref.
+func (s *session) resetSince(m *Module, since string) {
+	switch {
+	case s.cfg.Since == "":
+		m.since, m.edited = nil, nil
+	case since == "" || m.gitDir == "":
+		dir, err := gitDir(m.Root)
+		if err != nil {
+			fmt.Fprintln(s.eout, "error: -since:", err)
+			return
+		}
+		m.since, m.edited, m.gitDir = map[string]bool{}, map[string]bool{}, dir
+		err = s.watchFiles()
+		if err != nil {
+			fmt.Fprintln(s.eout, "error:", err)
+		}
+	case s.cfg.Since != since:
+		m.since = map[string]bool{}
+	}
+}
+
+// render redraws the dashboard and updates everything else that reflects the results.
+func (s *session) render() {
+	if s.pick != nil {
+		s.pick.render(s.out)
+		s.display.Forget()
+		return
+	}
+
+	var banner, footer string
+	if s.prompt != nil {
+		banner = "tests to run (go test -run), enter to apply, empty for all, esc to cancel: " + *s.prompt + "█"
+	} else if s.notice != "" {
+		banner = s.notice
+	} else if s.paused {
+		banner = "⏸ paused, press p to resume"
+	} else if restored := restoredBanner(s.modules); restored != "" {
+		banner = restored
+	} else if s.idle {
+		banner = "watching, the first change starts a build"
+	}
+	var notes []string
+	if s.walking > 0 {
+		notes = append(notes, fmt.Sprintf("watching directories… %d", s.watched))
+	} else if s.cfg.EventsFrom == "" {
+		notes = append(notes, fmt.Sprintf("watching %d directories, %d Go files", s.watched, s.goFiles))
+	}
+	if s.toggledVerbose {
+		if s.modules[0].Builder.Verbose() {
+			notes = append(notes, "verbose tests, press v for quiet")
+		} else {
+			notes = append(notes, "quiet tests, press v for verbose")
+		}
+	}
+	if s.cfg.Preset != "" {
+		notes = append(notes, "preset "+s.cfg.Preset+", press n for the next")
+	}
+	if s.focus != "" {
+		notes = append(notes, "tests focused on "+s.focus+", press t to change")
+	}
+	if s.cfg.Totals {
+		notes = append(notes, s.totals.String())
+	}
+	if versions := goVersions(s.modules); len(versions) > 0 {
+		notes = append(notes, strings.Join(versions, ", "))
+	}
+	footer = strings.Join(notes, " · ")
+	if !s.ci && !s.observe {
+		s.display.Show(s.modules, banner, footer)
+	}
+
+	if s.cfg.StatusFile != "" {
+		if compact := compactStatus(s.modules); compact != s.lastCompact {
+			err := writeFileAtomic(s.cfg.StatusFile, []byte(compact+"\n"))
+			if err != nil {
+				fmt.Fprintln(s.eout, "error:", err)
+			}
+			s.lastCompact = compact
+		}
+	}
+
+	if status := overallStatus(s.modules); status != StatusDirty {
+		if s.lastStatus != StatusDirty && status != s.lastStatus {
+			results := reportResults(s.modules)
+			if status == StatusBad {
+				s.failing = failingKeys(results)
+			}
+			// Going back to ok is about the commands that had failed.
+			transition(s.cfg, s.lastStatus, status, s.failing, s.notified, failureSummary(results))
+			if s.lastStatus == StatusBad && status == StatusOk && s.cfg.Celebrate != "" {
+				celebrate(s.cfg.Celebrate, s.cfg.Shell)
+			}
+		}
+		s.lastStatus = status
+	}
+
+	if s.cfg.HTML != "" && allDone(s.modules) {
+		// Rendering runs this for every key and redraw, it's only written when it changed.
+		report, err := htmlReport(reportResults(s.modules))
+		if err == nil && report != s.lastHTML {
+			err = exportHTML(report, s.cfg.HTML)
+			s.lastHTML = report
+		}
+		if err != nil {
+			fmt.Fprintln(s.eout, "error:", err)
+		}
+	}
+
+	if s.cfg.Markdown != "" {
+		if report := markdownReport(overallStatus(s.modules), reportResults(s.modules)); report != s.lastMarkdown {
+			err := exportMarkdown(report, s.cfg.Markdown)
+			if err != nil {
+				fmt.Fprintln(s.eout, "error:", err)
+			}
+			s.lastMarkdown = report
+		}
+	}
+
+	if s.cfg.Restore && allDone(s.modules) && restoredBanner(s.modules) == "" {
+		s.state.keep(s.modules)
+	}
+
+	if s.cfg.JUnit != "" && allDone(s.modules) {
+		report, err := junitReport(moduleCases(s.modules))
+		if err == nil && string(report) != s.lastJUnit {
+			err = writeFileAtomic(s.cfg.JUnit, report)
+			s.lastJUnit = string(report)
+		}
+		if err != nil {
+			fmt.Fprintln(s.eout, "error:", err)
+		}
+	}
+
+	if s.cfg.Pipe != "" && allDone(s.modules) {
+		var results []CommandResult
+		for _, m := range s.modules {
+			results = append(results, m.Build, m.Test)
+		}
+		// The editor takes each batch as new errors, so the same ones go only once.
+		if errs := pipeErrors(results...); errs != s.lastPipe {
+			written, err := writePipe(s.cfg.Pipe, errs)
+			if err != nil {
+				fmt.Fprintln(s.eout, "error:", err)
+			}
+			if written {
+				s.lastPipe = errs
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// goEnv are the environment variables that change what go commands do without showing in their args.
+var goEnv = []string{"GOFLAGS", "GOOS", "GOARCH", "CGO_ENABLED", "GOEXPERIMENT", "GOMAXPROCS"}
+
+// goCommand is the go binary run in place of the go on $PATH, set with -go.
+var goCommand = "go"
+
+// withGo returns args with goCommand run in place of go.
+func withGo(args []string) []string {
+	if len(args) == 0 || args[0] != "go" {
+		return args
+	}
+	return append([]string{goCommand}, args[1:]...)
+}
+
+// shellMeta are the characters that only mean something to a shell.
+const shellMeta = "|&;<>()$`\\\"'*?~"
+
+// needsShell reports whether command is run in a shell, when useShell is set or it uses shell syntax.
+func needsShell(command string, useShell bool) bool {
+	return useShell || strings.ContainsAny(command, shellMeta)
+}
+
+// commandArgs splits command into Args, wrapping it in a shell when it needs one.
+func commandArgs(command string, useShell bool) []string {
+	if !needsShell(command, useShell) {
+		return strings.Fields(command)
+	}
+
+	if runtime.GOOS == "windows" {
+		return []string{"cmd", "/c", command}
+	}
+
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "sh"
+	}
+	return []string{shell, "-c", command}
+}
+
+// runOnce runs command to completion with its output going straight to out and eout,
+// for -setup-cmd and -teardown-cmd.
+func runOnce(command string, useShell bool, out, eout io.Writer) error {
+	args := commandArgs(command, useShell)
+	if len(args) == 0 {
+		return nil
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = out
+	cmd.Stderr = eout
+	return cmd.Run()
+}
+
+// commandLine renders args run in dir as a command that can be pasted into a shell,
+// including any of the goEnv variables set in env (or gowatch's environment when it's nil).
+func commandLine(dir string, env []string, args []string) string {
+	if env == nil {
+		env = os.Environ()
+	}
+	var words []string
+	if dir != "" && dir != "." {
+		words = append(words, "cd", shellQuote(dir), "&&")
+	}
+	for _, name := range goEnv {
+		if value, ok := lookupEnv(env, name); ok {
+			words = append(words, name+"="+shellQuote(value))
+		}
+	}
+	for _, arg := range args {
+		words = append(words, shellQuote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// shellQuote quotes s for a POSIX shell if it needs it.
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, shellMeta+" \t\n#") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// lookupEnv finds the value of the variable called name in env, the last one wins like for exec.Cmd.
+func lookupEnv(env []string, name string) (string, bool) {
+	for i := len(env) - 1; i >= 0; i-- {
+		if value, found := strings.CutPrefix(env[i], name+"="); found {
+			return value, true
+		}
+	}
+	return "", false
+}
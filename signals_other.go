@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// notifyResize can't tell when the terminal is resized on this platform, the width is only measured once.
+func notifyResize(c chan<- os.Signal) {}
+
+// parseSignal always returns nil, there are no signals to send gowatch on this platform.
+func parseSignal(name string) (os.Signal, error) {
+	return nil, nil
+}
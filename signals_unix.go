@@ -0,0 +1,36 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// notifyResize sends to c whenever the terminal is resized.
+func notifyResize(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGWINCH)
+}
+
+// signalNames are the signals that can be used for -rebuild-signal and -reload-signal.
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"ALRM": syscall.SIGALRM,
+}
+
+// parseSignal returns the signal called name, e.g. USR1 or SIGUSR1, nil for an empty name.
+func parseSignal(name string) (os.Signal, error) {
+	if name == "" {
+		return nil, nil
+	}
+	sig, found := signalNames[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !found {
+		return nil, fmt.Errorf("unknown signal %q, must be HUP, USR1, USR2 or ALRM", name)
+	}
+	return sig, nil
+}
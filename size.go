@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// withOutputDir makes a "go build" command write its binaries into dir, other commands are left alone.
+func withOutputDir(args []string, dir string) []string {
+	// A trailing separator makes go build treat -o as a directory, which works for any number of main packages.
+	return goFlags(args, "build", "-o", dir+string(filepath.Separator))
+}
+
+// pathSize returns the size of the file at path, or of the files in it if it's a directory.
+func pathSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if info.Mode().IsRegular() {
+		return info.Size(), nil
+	}
+	return dirSize(path)
+}
+
+// dirSize returns the total size of the files in dir.
+func dirSize(dir string) (int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return 0, err
+		}
+		if info.Mode().IsRegular() {
+			total += info.Size()
+		}
+	}
+	return total, nil
+}
+
+// formatSize formats a byte count for humans.
+func formatSize(n int64) string {
+	abs := n
+	if abs < 0 {
+		abs = -abs
+	}
+	switch {
+	case abs >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case abs >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// formatSizeDelta formats a change in size with its sign.
+func formatSizeDelta(n int64) string {
+	if n > 0 {
+		return "+" + formatSize(n)
+	}
+	return formatSize(n)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// socketEvent is a line of JSON sent to the -socket clients.
+type socketEvent struct {
+	Event  string       `json:"event"` // trigger, start or result
+	Dir    string       `json:"dir"`
+	File   string       `json:"file,omitempty"`
+	Name   string       `json:"name,omitempty"`
+	Status string       `json:"status,omitempty"`
+	Errors []BuildError `json:"errors,omitempty"`
+}
+
+// writeTimeout is how long a client gets to read an event before it's dropped,
+// and clientBacklog how many events may wait for it before it's dropped too.
+const (
+	writeTimeout  = time.Second
+	clientBacklog = 64
+)
+
+// Broadcaster sends events to every client connected to a Unix socket. Each
+// client is written to by a goroutine of its own, from its backlog of events,
+// so a slow one doesn't hold up the loop or the others.
+type Broadcaster struct {
+	listener net.Listener
+
+	lock    sync.Mutex
+	clients map[net.Conn]chan []byte
+	closed  bool
+}
+
+// ListenSocket listens on the Unix socket at path, replacing any stale socket left there.
+func ListenSocket(path string) (*Broadcaster, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Broadcaster{listener: listener, clients: map[net.Conn]chan []byte{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				logger.Debug("socket closed", "err", err)
+				return
+			}
+			b.connected(conn)
+		}
+	}()
+	return b, nil
+}
+
+// connected starts writing the events to conn, unless the socket was closed
+// while it was being accepted.
+func (b *Broadcaster) connected(conn net.Conn) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.closed {
+		conn.Close()
+		return
+	}
+	logger.Debug("socket client connected")
+	events := make(chan []byte, clientBacklog)
+	b.clients[conn] = events
+	go b.write(conn, events)
+}
+
+// write writes the events to conn until there are no more, dropping it if it
+// has gone away or doesn't keep up.
+func (b *Broadcaster) write(conn net.Conn, events chan []byte) {
+	defer conn.Close()
+	for data := range events {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		_, err := conn.Write(data)
+		if err != nil {
+			logger.Debug("socket client dropped", "err", err)
+			b.drop(conn)
+			return
+		}
+	}
+}
+
+// drop disconnects conn, if it's still connected. b.lock must not be held.
+func (b *Broadcaster) drop(conn net.Conn) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.remove(conn)
+}
+
+// remove stops the events to conn, whose write then closes it. b.lock must be held.
+func (b *Broadcaster) remove(conn net.Conn) {
+	if events, found := b.clients[conn]; found {
+		close(events)
+		delete(b.clients, conn)
+	}
+}
+
+// Send queues ev for every client, dropping those too far behind to take it.
+func (b *Broadcaster) Send(ev socketEvent) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		logger.Error("encoding socket event", "err", err)
+		return
+	}
+	data = append(data, '\n')
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for conn, events := range b.clients {
+		select {
+		case events <- data:
+		default:
+			logger.Debug("socket client dropped, it isn't reading the events")
+			b.remove(conn)
+		}
+	}
+}
+
+// Close stops listening, disconnects the clients and removes the socket.
+func (b *Broadcaster) Close() error {
+	err := b.listener.Close()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.closed = true
+	for conn := range b.clients {
+		// Not waiting for the events still to be written.
+		conn.Close()
+		b.remove(conn)
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBroadcaster(t *testing.T) {
+	// Short, a socket's path can't be long.
+	dir, err := os.MkdirTemp("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := ListenSocket(filepath.Join(dir, "s"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := net.Dial("unix", filepath.Join(dir, "s"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	lines := bufio.NewReader(client)
+	// Until it has been accepted.
+	for accepted := false; !accepted; {
+		b.lock.Lock()
+		accepted = len(b.clients) == 1
+		b.lock.Unlock()
+	}
+	b.Send(socketEvent{Event: "start", Dir: ".", Name: "Build"})
+	line, err := lines.ReadString('\n')
+	if err != nil || !strings.Contains(line, `"event":"start"`) {
+		t.Errorf("client read %q, %v, want the start event", line, err)
+	}
+
+	if err := b.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	// Accepted just as it closed, it's disconnected rather than added.
+	late, other := net.Pipe()
+	defer other.Close()
+	b.connected(late)
+	if _, err := late.Write([]byte("x")); err == nil {
+		t.Errorf("a client connected after Close is still open")
+	}
+	b.Send(socketEvent{Event: "result"})
+}
+
+func TestBroadcasterSlowClient(t *testing.T) {
+	b := &Broadcaster{clients: map[net.Conn]chan []byte{}}
+	conn, other := net.Pipe()
+	defer other.Close()
+	b.connected(conn)
+	// Nothing reads other, the backlog fills up and the client is dropped
+	// without Send ever waiting for it.
+	for i := 0; i <= clientBacklog+1; i++ {
+		b.Send(socketEvent{Event: "result"})
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.clients) != 0 {
+		t.Errorf("a client that doesn't read is still connected")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// StateFile is where -restore keeps the results of the last session, in the working directory.
+const StateFile = ".gowatch-state.json"
+
+// savedState is the layout of the state file.
+type savedState struct {
+	// Dir is the working directory it was saved from, it's only restored there.
+	Dir     string        `json:"dir"`
+	Saved   time.Time     `json:"saved"`
+	Modules []savedModule `json:"modules"`
+}
+
+// savedModule is the last finished results of a module.
+type savedModule struct {
+	Dir      string          `json:"dir"`
+	Build    CommandResult   `json:"build"`
+	Test     CommandResult   `json:"test"`
+	Examples CommandResult   `json:"examples"`
+	Targets  []CommandResult `json:"targets,omitempty"`
+	Tidy     CommandResult   `json:"tidy"`
+}
+
+// stateKeeper holds the results to save on exit. They're taken by the event
+// loop and written once it's been stopped, so it has a lock of its own.
+type stateKeeper struct {
+	lock  sync.Mutex
+	state *savedState
+}
+
+// keep takes the results of modules to be saved on exit.
+func (sk *stateKeeper) keep(modules []*Module) {
+	state := savedState{Saved: time.Now()}
+	for _, m := range modules {
+		state.Modules = append(state.Modules, savedModule{
+			Dir:      m.Dir,
+			Build:    m.Build,
+			Test:     m.Test,
+			Examples: m.Examples,
+			Targets:  append([]CommandResult(nil), m.Targets...),
+			Tidy:     m.Tidy,
+		})
+	}
+	sk.lock.Lock()
+	defer sk.lock.Unlock()
+	sk.state = &state
+}
+
+// save writes the results last kept to path, leaving it as it was if nothing finished.
+func (sk *stateKeeper) save(path string) error {
+	sk.lock.Lock()
+	defer sk.lock.Unlock()
+	if sk.state == nil {
+		return nil
+	}
+	return saveState(path, *sk.state)
+}
+
+// saveState writes state to path as JSON, keyed to the working directory.
+func saveState(path string, state savedState) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	state.Dir = wd
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// loadState reads the state saved at path. It's empty if there isn't one or it
+// was saved from another working directory.
+func loadState(path string) (savedState, error) {
+	var state savedState
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return savedState{}, fmt.Errorf("%s: %v", path, err)
+	}
+	if wd, err := os.Getwd(); err != nil || filepath.Clean(state.Dir) != wd {
+		return savedState{}, nil
+	}
+	return state, nil
+}
+
+// restoreState shows the saved results of each module until it's built again,
+// marked as being from the last session.
+func restoreState(modules []*Module, state savedState) {
+	for _, saved := range state.Modules {
+		for _, m := range modules {
+			if m.Dir != saved.Dir {
+				continue
+			}
+			m.Build = saved.Build
+			if m.Builder.HasTests() {
+				m.Test = saved.Test
+			}
+			if m.Builder.HasExamples() {
+				m.Examples = saved.Examples
+			}
+			if m.Builder.HasTidy() {
+				m.Tidy = saved.Tidy
+			}
+			// The targets are only the same ones if there are as many.
+			if len(saved.Targets) == len(m.Targets) {
+				m.Targets = saved.Targets
+			}
+			m.restored = state.Saved
+		}
+	}
+}
+
+// restoredBanner describes the results restored from the last session, empty
+// once every module has been built again.
+func restoredBanner(modules []*Module) string {
+	var saved time.Time
+	for _, m := range modules {
+		if !m.restored.IsZero() {
+			saved = m.restored
+		}
+	}
+	if saved.IsZero() {
+		return ""
+	}
+	return "⟲ stale, the results of the last session at " + saved.Format("Jan 2 15:04") + " until the first build finishes"
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// compactStatus renders every module's results on a single uncoloured line for status bars.
+func compactStatus(modules []*Module) string {
+	var parts []string
+	for _, m := range modules {
+		part := StatusIcon[m.Build.Status] + commandTag(m.Builder.buildCmd.Name)
+		if m.Builder.HasTests() {
+			part += " " + StatusIcon[m.Test.Status] + commandTag(m.Builder.testCmd.Name)
+		}
+		if m.Builder.HasExamples() {
+			part += " " + StatusIcon[m.Examples.Status] + commandTag(m.Builder.exampleCmd.Name)
+		}
+		if len(modules) > 1 {
+			part = m.Dir + " " + part
+		}
+		parts = append(parts, part)
+	}
+	return "gowatch: " + strings.Join(parts, " | ")
+}
+
+// writeFileAtomic replaces the file at path with data so readers never see it half written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
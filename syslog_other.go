@@ -0,0 +1,22 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// sysLog does nothing, there's no system log on this platform.
+type sysLog struct{}
+
+// openSysLog always fails, -syslog is ignored with a warning.
+func openSysLog() (*sysLog, error) {
+	return nil, fmt.Errorf("-syslog isn't supported on %s, ignoring it", runtime.GOOS)
+}
+
+func (l *sysLog) Record(cr CommandResult) {}
+
+func (l *sysLog) Close() error {
+	return nil
+}
@@ -0,0 +1,45 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"time"
+)
+
+// sysLog sends the results to the system log for -syslog, journald picks them up on Linux.
+type sysLog struct {
+	w *syslog.Writer
+}
+
+// openSysLog connects to the local system log.
+func openSysLog() (*sysLog, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, "gowatch")
+	if err != nil {
+		return nil, fmt.Errorf("-syslog: %v", err)
+	}
+	return &sysLog{w: w}, nil
+}
+
+// Record logs cr at info priority if it passed, err if it failed.
+func (l *sysLog) Record(cr CommandResult) {
+	msg := fmt.Sprintf("%s: %s %s (%s)", cr.Dir, cr.Label(), cr.Status, cr.Duration.Round(time.Millisecond))
+	if cr.Exit != "" {
+		msg += ", " + cr.Exit
+	}
+	var err error
+	if cr.Status == StatusBad {
+		err = l.w.Err(msg)
+	} else {
+		err = l.w.Info(msg)
+	}
+	if err != nil {
+		logger.Debug("writing to the system log", "err", err)
+	}
+}
+
+// Close disconnects from the system log.
+func (l *sysLog) Close() error {
+	return l.w.Close()
+}
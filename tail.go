@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// tailRefresh is how often the display is redrawn with the lines the -run command printed since.
+const tailRefresh = 250 * time.Millisecond
+
+// logTail keeps the last lines the -run command printed, for -tail. It's
+// written by the command as it runs and read by the display, so it has a lock of its own.
+type logTail struct {
+	lock sync.Mutex
+	max  int
+	// lines are the complete lines, partial the start of the next one.
+	lines   []string
+	partial string
+	// changed is set by each write until the lines are next read.
+	changed bool
+}
+
+// newLogTail makes a tail keeping the last max lines.
+func newLogTail(max int) *logTail {
+	return &logTail{max: max}
+}
+
+// Write adds the lines in p, dropping the oldest ones beyond the max.
+func (lt *logTail) Write(p []byte) (int, error) {
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+	text := lt.partial + strings.ReplaceAll(string(p), "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+	lt.partial = lines[len(lines)-1]
+	lt.lines = append(lt.lines, lines[:len(lines)-1]...)
+	if len(lt.lines) > lt.max {
+		lt.lines = append([]string(nil), lt.lines[len(lt.lines)-lt.max:]...)
+	}
+	lt.changed = true
+	return len(p), nil
+}
+
+// reset empties the tail for a new run of the command.
+func (lt *logTail) reset() {
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+	lt.lines, lt.partial = nil, ""
+	lt.changed = true
+}
+
+// Lines returns the last lines, including one still being printed.
+func (lt *logTail) Lines() []string {
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+	lt.changed = false
+	lines := append([]string(nil), lt.lines...)
+	if lt.partial != "" {
+		lines = append(lines, lt.partial)
+	}
+	if len(lines) > lt.max {
+		lines = lines[len(lines)-lt.max:]
+	}
+	return lines
+}
+
+// Changed reports whether anything was written since the lines were last read.
+func (lt *logTail) Changed() bool {
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+	return lt.changed
+}
+
+// tailChanged reports whether the -run command of any of modules printed anything since it was last shown.
+func tailChanged(modules []*Module) bool {
+	for _, m := range modules {
+		if tail := m.Builder.runCmd.Tail; tail != nil && tail.Changed() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// TestCase is the result of a single (sub)test, from go test -json.
+type TestCase struct {
+	Package string
+	Test    string
+	// Result is pass, fail or skip.
+	Result  string
+	Output  string
+	Elapsed time.Duration
+}
+
+// testEvent is a line of go test -json output.
+type testEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Output  string
+	Elapsed float64
+}
+
+// parseTestJSON turns go test -json output back into the plain output go test
+// would have printed, returning it with the result of each test. It returns false
+// if output has no test events at all. Lines that aren't events, like build
+// errors, are kept as they are.
+func parseTestJSON(output string) (string, []TestCase, bool) {
+	var plain strings.Builder
+	var cases []TestCase
+	running := map[string]*strings.Builder{}
+	found := false
+	for _, line := range strings.SplitAfter(output, "\n") {
+		var ev testEvent
+		if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &ev) != nil || ev.Action == "" {
+			plain.WriteString(line)
+			continue
+		}
+		found = true
+		key := ev.Package + " " + ev.Test
+		switch ev.Action {
+		case "output":
+			plain.WriteString(ev.Output)
+			if ev.Test != "" {
+				if running[key] == nil {
+					running[key] = &strings.Builder{}
+				}
+				running[key].WriteString(ev.Output)
+			}
+		case "pass", "fail", "skip":
+			if ev.Test == "" {
+				continue
+			}
+			tc := TestCase{Package: ev.Package, Test: ev.Test, Result: ev.Action, Elapsed: time.Duration(ev.Elapsed * float64(time.Second))}
+			if out := running[key]; out != nil {
+				tc.Output = out.String()
+			}
+			delete(running, key)
+			cases = append(cases, tc)
+		}
+	}
+	return plain.String(), cases, found
+}
+
+// printTAP writes the tests of cr as a TAP document, with -tap. Failures of
+// anything else, like the build, are written as comments so TAP consumers skip them.
+func printTAP(out io.Writer, cr CommandResult) {
+	cases := cr.Tests.Cases
+	if len(cases) == 0 {
+		if cr.Status == StatusBad {
+			fmt.Fprintf(out, "# %s failed\n", cr.Label())
+			tapComment(out, cr.Output)
+		}
+		return
+	}
+
+	fmt.Fprintln(out, "TAP version 13")
+	fmt.Fprintf(out, "1..%d\n", len(cases))
+	for i, tc := range cases {
+		name := tc.Package + "/" + tc.Test
+		switch tc.Result {
+		case "pass":
+			fmt.Fprintf(out, "ok %d - %s\n", i+1, name)
+		case "skip":
+			fmt.Fprintf(out, "ok %d - %s # SKIP\n", i+1, name)
+		default:
+			fmt.Fprintf(out, "not ok %d - %s\n", i+1, name)
+			tapComment(out, tc.Output)
+		}
+	}
+}
+
+// tapComment writes each line of text as a TAP comment.
+func tapComment(out io.Writer, text string) {
+	text = strings.TrimRight(text, "\n")
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintln(out, "# "+line)
+	}
+}
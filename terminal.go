@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/fatih/color.v0"
+)
+
+// isTerminal reports whether w writes to a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// terminalWidth returns the number of columns of the terminal, or 80 if it can't tell.
+func terminalWidth() int {
+	if size, err := stty(os.Stdin, "size"); err == nil {
+		fields := strings.Fields(size)
+		if len(fields) == 2 {
+			if cols, err := strconv.Atoi(fields[1]); err == nil && cols > 0 {
+				return cols
+			}
+		}
+	}
+	if cols, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && cols > 0 {
+		return cols
+	}
+	return 80
+}
+
+// setColor turns colored output on or off for mode always, never or auto (only on a terminal).
+func setColor(mode string, out io.Writer) error {
+	switch mode {
+	case "always":
+		color.NoColor = false
+	case "never":
+		color.NoColor = true
+	case "auto", "":
+		color.NoColor = !isTerminal(out)
+	default:
+		return fmt.Errorf("invalid -color %q, must be always, auto or never", mode)
+	}
+	return nil
+}
+
+// colorAttributes maps the color names usable in the config file to their attributes.
+var colorAttributes = map[string]color.Attribute{
+	"bold":      color.Bold,
+	"faint":     color.Faint,
+	"underline": color.Underline,
+	"black":     color.FgBlack,
+	"red":       color.FgRed,
+	"green":     color.FgGreen,
+	"yellow":    color.FgYellow,
+	"blue":      color.FgBlue,
+	"magenta":   color.FgMagenta,
+	"cyan":      color.FgCyan,
+	"white":     color.FgWhite,
+}
+
+// parseColor turns a color like "bold cyan" into a sprint func.
+func parseColor(spec string) (func(a ...interface{}) string, error) {
+	var attrs []color.Attribute
+	for _, word := range strings.Fields(spec) {
+		attr, ok := colorAttributes[strings.ToLower(word)]
+		if !ok {
+			return nil, fmt.Errorf("unknown color %q", word)
+		}
+		attrs = append(attrs, attr)
+	}
+	return color.New(attrs...).SprintFunc(), nil
+}
+
+// setColors replaces the colors of the parts named in colors (ok, bad, dirty, warn, name, text, dim and cached).
+func setColors(colors map[string]string) error {
+	targets := map[string]*func(a ...interface{}) string{
+		"ok":     &ok,
+		"bad":    &bad,
+		"dirty":  &refresh,
+		"warn":   &warn,
+		"name":   &cmdName,
+		"text":   &normal,
+		"dim":    &dim,
+		"cached": &cached,
+	}
+	for part, spec := range colors {
+		target, found := targets[part]
+		if !found {
+			return fmt.Errorf("unknown color setting %q, must be ok, bad, dirty, warn, name, text, dim or cached", part)
+		}
+		sprint, err := parseColor(spec)
+		if err != nil {
+			return fmt.Errorf("colors: %s: %v", part, err)
+		}
+		*target = sprint
+	}
+	return nil
+}
+
+// Theme overrides the icons and colors of the statuses (ok, bad and dirty) of a command.
+type Theme struct {
+	Icons  map[string]string `yaml:"icons"`
+	Colors map[string]string `yaml:"colors"`
+}
+
+// statusTheme is a Theme ready to use.
+type statusTheme struct {
+	icons  map[Status]string
+	colors map[Status]func(a ...interface{}) string
+}
+
+// commandThemes are the themes from the config file by lowercase command name.
+var commandThemes map[string]statusTheme
+
+// themeStatuses are the names of the statuses in a theme.
+var themeStatuses = map[string]Status{"ok": StatusOk, "bad": StatusBad, "dirty": StatusDirty, "warn": StatusWarn}
+
+// setThemes replaces commandThemes with themes, the themes section of the config file.
+func setThemes(themes map[string]Theme) error {
+	parsed := map[string]statusTheme{}
+	for name, theme := range themes {
+		st := statusTheme{icons: map[Status]string{}, colors: map[Status]func(a ...interface{}) string{}}
+		for part, icon := range theme.Icons {
+			status, found := themeStatuses[part]
+			if !found {
+				return fmt.Errorf("themes: %s: unknown status %q, must be ok, bad, dirty or warn", name, part)
+			}
+			st.icons[status] = icon
+		}
+		for part, spec := range theme.Colors {
+			status, found := themeStatuses[part]
+			if !found {
+				return fmt.Errorf("themes: %s: unknown status %q, must be ok, bad, dirty or warn", name, part)
+			}
+			sprint, err := parseColor(spec)
+			if err != nil {
+				return fmt.Errorf("themes: %s: %s: %v", name, part, err)
+			}
+			st.colors[status] = sprint
+		}
+		parsed[strings.ToLower(name)] = st
+	}
+	commandThemes = parsed
+	return nil
+}
+
+// statusStyle returns the icon and color for status in the command called name,
+// from its theme or else the usual ones.
+func statusStyle(name string, status Status, color func(a ...interface{}) string) (string, func(a ...interface{}) string) {
+	icon := StatusIcon[status]
+	theme := commandThemes[strings.ToLower(name)]
+	if themed, found := theme.icons[status]; found {
+		icon = themed
+	}
+	if themed, found := theme.colors[status]; found {
+		color = themed
+	}
+	return icon, color
+}
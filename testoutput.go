@@ -0,0 +1,278 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TestFailure is a failed test and the package it's in.
+type TestFailure struct {
+	Package string
+	Test    string
+}
+
+// PackageResult is the outcome of testing one package.
+type PackageResult struct {
+	Package string
+	Passed  bool
+	// Seed is the -shuffle seed the tests ran with, if they were shuffled.
+	Seed string
+	// Cached is set when go test showed the result it had cached instead of running the tests.
+	Cached bool
+}
+
+// TestReport is what could be understood from go test output.
+type TestReport struct {
+	Packages []PackageResult
+	Failures []TestFailure
+	// Untested are the packages without any test files.
+	Untested []string
+	// Cases are the results of every test, when it was run with go test -json.
+	Cases []TestCase
+	// Races counts the data races the race detector reported.
+	Races int
+}
+
+var (
+	// testFail matches a failed (sub)test, e.g. "--- FAIL: TestFoo (0.01s)".
+	testFail = regexp.MustCompile(`^\s*--- FAIL: (\S+)`)
+	// packageDone matches the line summarising a package, e.g. "ok  	example.com/foo	0.01s".
+	packageDone = regexp.MustCompile(`^(ok|FAIL)\s+(\S+)`)
+	// noTestFiles matches the line for a package without tests, e.g. "?   	example.com/foo	[no test files]".
+	noTestFiles = regexp.MustCompile(`^\?\s+(\S+)\s+\[no test files\]`)
+	// dataRace starts each report of the race detector.
+	dataRace = regexp.MustCompile(`^WARNING: DATA RACE`)
+	// cachedResult matches the line of a package whose result was cached, e.g. "ok  	example.com/foo	(cached)".
+	cachedResult = regexp.MustCompile(`^ok\s+\S+\s+(\(cached\))`)
+	// shuffleSeed matches the seed go test -v prints for shuffled tests.
+	shuffleSeed = regexp.MustCompile(`^-test\.shuffle (\d+)`)
+)
+
+// ParseTestOutput picks the package results and failing tests out of go test output.
+func ParseTestOutput(output string) TestReport {
+	var report TestReport
+	var pending []string
+	var seed string
+	for _, line := range strings.Split(output, "\n") {
+		if m := shuffleSeed.FindStringSubmatch(line); m != nil {
+			seed = m[1]
+			continue
+		}
+		if m := testFail.FindStringSubmatch(line); m != nil {
+			// Only the top level test can be rerun on its own.
+			name := strings.SplitN(m[1], "/", 2)[0]
+			if len(pending) == 0 || pending[len(pending)-1] != name {
+				pending = append(pending, name)
+			}
+			continue
+		}
+
+		if m := noTestFiles.FindStringSubmatch(line); m != nil {
+			report.Untested = append(report.Untested, m[1])
+			continue
+		}
+		if dataRace.MatchString(line) {
+			report.Races++
+			continue
+		}
+
+		m := packageDone.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		report.Packages = append(report.Packages, PackageResult{Package: m[2], Passed: m[1] == "ok", Seed: seed, Cached: cachedResult.MatchString(line)})
+		seed = ""
+		for _, name := range pending {
+			report.Failures = append(report.Failures, TestFailure{Package: m[2], Test: name})
+		}
+		pending = nil
+	}
+	return report
+}
+
+// withoutUntested drops the lines of the packages without test files from go
+// test output, which only say so, counting them in a line at the end instead.
+func withoutUntested(output string) string {
+	var kept []string
+	untested := 0
+	for _, line := range strings.Split(output, "\n") {
+		if noTestFiles.MatchString(line) {
+			untested++
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if untested == 0 {
+		return output
+	}
+	output = strings.TrimRight(strings.Join(kept, "\n"), "\n")
+	if output != "" {
+		output += "\n"
+	}
+	return output + plural(untested, "package") + " without test files\n"
+}
+
+// collapsePassing cuts the output of each package whose tests passed down to
+// its "ok" line, keeping the output of those that failed in full.
+func collapsePassing(output string) string {
+	var kept, pkg []string
+	for _, line := range strings.Split(output, "\n") {
+		if noTestFiles.MatchString(line) {
+			kept = append(kept, line)
+			continue
+		}
+		m := packageDone.FindStringSubmatch(line)
+		switch {
+		case m == nil:
+			pkg = append(pkg, line)
+		case m[1] == "ok":
+			kept = append(kept, line)
+			pkg = nil
+		default:
+			kept = append(append(kept, pkg...), line)
+			pkg = nil
+		}
+	}
+	// Whatever comes after the last package, like go vet's failures.
+	return strings.Join(append(kept, pkg...), "\n")
+}
+
+// isUntested reports whether cr is the passing test run of a package without test files.
+func isUntested(cr CommandResult) bool {
+	return cr.Status == StatusOk && len(cr.Tests.Untested) > 0 && len(cr.Tests.Packages) == 0
+}
+
+// rerunArgs makes a go test command that runs only the failed test, or nil if testArgs isn't go test.
+func rerunArgs(testArgs []string, f TestFailure) []string {
+	return failuresArgs(testArgs, []TestFailure{f})
+}
+
+// failuresArgs makes a go test command that runs only the failed tests, or nil if testArgs isn't go test.
+func failuresArgs(testArgs []string, failures []TestFailure) []string {
+	if len(testArgs) < 2 || testArgs[0] != "go" || testArgs[1] != "test" || len(failures) == 0 {
+		return nil
+	}
+
+	args := []string{testArgs[0], testArgs[1]}
+	for i := 2; i < len(testArgs); i++ {
+		arg := testArgs[i]
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		value := takesValue(arg) && i+1 < len(testArgs)
+		if arg != "-run" && !strings.HasPrefix(arg, "-run=") {
+			args = append(args, arg)
+			if value {
+				args = append(args, testArgs[i+1])
+			}
+		}
+		if value {
+			i++
+		}
+	}
+
+	var tests, pkgs []string
+	seen := map[string]bool{}
+	for _, f := range failures {
+		if !seen["test "+f.Test] {
+			seen["test "+f.Test] = true
+			tests = append(tests, regexp.QuoteMeta(f.Test))
+		}
+		if !seen["pkg "+f.Package] {
+			seen["pkg "+f.Package] = true
+			pkgs = append(pkgs, f.Package)
+		}
+	}
+	run := "^" + tests[0] + "$"
+	if len(tests) > 1 {
+		run = "^(" + strings.Join(tests, "|") + ")$"
+	}
+	return append(append(args, "-run", run), pkgs...)
+}
+
+// failedSeeds describes the shuffle seeds of the packages that failed, to reproduce them.
+func (tr TestReport) failedSeeds() []string {
+	var seeds []string
+	for _, pkg := range tr.Packages {
+		if !pkg.Passed && pkg.Seed != "" {
+			seeds = append(seeds, "-shuffle="+pkg.Seed+" "+pkg.Package)
+		}
+	}
+	return seeds
+}
+
+// allCached reports whether every package's result was cached, so none of the tests actually ran.
+func (tr TestReport) allCached() bool {
+	for _, pkg := range tr.Packages {
+		if !pkg.Cached {
+			return false
+		}
+	}
+	return len(tr.Packages) > 0
+}
+
+// markCached colors output with text, apart from the (cached) of the packages
+// whose results were cached, which is in the cached color to stand out.
+func markCached(text func(a ...interface{}) string, output string) string {
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		m := cachedResult.FindStringSubmatchIndex(line)
+		if m == nil {
+			lines[i] = text(line)
+			continue
+		}
+		lines[i] = text(line[:m[2]]) + cached(line[m[2]:m[3]])
+		if rest := line[m[3]:]; rest != "" {
+			lines[i] += text(rest)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Summary describes how many tests failed in how many packages, e.g.
+// "FAIL: 2 tests in 1 package", or is empty when nothing failed.
+func (tr TestReport) Summary() string {
+	packages := 0
+	for _, pkg := range tr.Packages {
+		if !pkg.Passed {
+			packages++
+		}
+	}
+	if packages == 0 {
+		return ""
+	}
+	if len(tr.Failures) == 0 {
+		// e.g. the package didn't compile
+		return "FAIL: " + plural(packages, "package")
+	}
+	return "FAIL: " + plural(len(tr.Failures), "test") + " in " + plural(packages, "package")
+}
+
+// sameFailures reports whether a and b are the same tests, in any order.
+func sameFailures(a, b []TestFailure) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[TestFailure]bool{}
+	for _, f := range a {
+		seen[f] = true
+	}
+	for _, f := range b {
+		if !seen[f] {
+			return false
+		}
+	}
+	return true
+}
+
+// plural formats n things, e.g. "1 test" or "2 tests".
+func plural(n int, thing string) string {
+	if n == 1 {
+		return "1 " + thing
+	}
+	return strconv.Itoa(n) + " " + thing + "s"
+}
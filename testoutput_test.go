@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFailuresArgs(t *testing.T) {
+	failures := []TestFailure{{Test: "TestA", Package: "example.com/a"}}
+	tests := []struct {
+		test string
+		want string
+	}{
+		{"go test ./...", "go test -run ^TestA$ example.com/a"},
+		{"go test -p=4 ./...", "go test -p=4 -run ^TestA$ example.com/a"},
+		{"go test -p 4 ./...", "go test -p 4 -run ^TestA$ example.com/a"},
+		{"go test -run TestB ./...", "go test -run ^TestA$ example.com/a"},
+		{"go test -run=TestB -count 1 ./...", "go test -count 1 -run ^TestA$ example.com/a"},
+		{"go test -race -tags integration ./...", "go test -race -tags integration -run ^TestA$ example.com/a"},
+	}
+	for _, test := range tests {
+		if got := strings.Join(failuresArgs(strings.Fields(test.test), failures), " "); got != test.want {
+			t.Errorf("failuresArgs(%q) = %q, want %q", test.test, got, test.want)
+		}
+	}
+
+	both := append(failures, TestFailure{Test: "TestB", Package: "example.com/b"}, TestFailure{Test: "TestA", Package: "example.com/b"})
+	want := "go test -run ^(TestA|TestB)$ example.com/a example.com/b"
+	if got := strings.Join(failuresArgs([]string{"go", "test", "./..."}, both), " "); got != want {
+		t.Errorf("failuresArgs of several failures = %q, want %q", got, want)
+	}
+	if args := failuresArgs([]string{"make", "test"}, failures); args != nil {
+		t.Errorf("failuresArgs of a command that isn't go test = %q, want nil", args)
+	}
+}
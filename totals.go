@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// sessionTotals add up the builds and test runs of the session for -totals and
+// -summary. They're read on exit as well as by the event loop, so they have a lock of their own.
+type sessionTotals struct {
+	lock      sync.Mutex
+	builds    int
+	buildTime time.Duration
+	tests     int
+	testTime  time.Duration
+
+	// passed and failed count the builds and test runs by how they went.
+	passed       int
+	failed       int
+	longestBuild time.Duration
+	// failures counts how often each test has failed.
+	failures map[TestFailure]int
+}
+
+// addBuild counts a finished build that took d.
+func (st *sessionTotals) addBuild(d time.Duration, status Status) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	st.builds++
+	st.buildTime += d
+	st.count(status)
+	if d > st.longestBuild {
+		st.longestBuild = d
+	}
+}
+
+// addTests counts a finished test run that took d, with the tests that failed in it.
+func (st *sessionTotals) addTests(d time.Duration, status Status, failures []TestFailure) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	st.tests++
+	st.testTime += d
+	st.count(status)
+	for _, f := range failures {
+		if st.failures == nil {
+			st.failures = map[TestFailure]int{}
+		}
+		st.failures[f]++
+	}
+}
+
+// count adds a run that finished with status, the caller holds the lock.
+func (st *sessionTotals) count(status Status) {
+	switch status {
+	case StatusOk:
+		st.passed++
+	case StatusBad:
+		st.failed++
+	}
+}
+
+// String summarises the totals, e.g. "42 builds, 8m3s total · 40 test runs, 12m0s total".
+func (st *sessionTotals) String() string {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	return plural(st.builds, "build") + ", " + st.buildTime.Round(time.Second).String() + " total · " +
+		plural(st.tests, "test run") + ", " + st.testTime.Round(time.Second).String() + " total"
+}
+
+// printSessionSummary writes what happened over the whole session to out for -summary,
+// as gowatch exits.
+func printSessionSummary(out io.Writer, st *sessionTotals) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	fmt.Fprintln(out, "session summary:")
+	fmt.Fprintf(out, "  runs           %s (%d passed, %d failed)\n", plural(st.builds+st.tests, "run"), st.passed, st.failed)
+	if st.builds > 0 {
+		fmt.Fprintf(out, "  longest build  %s\n", st.longestBuild.Round(time.Millisecond))
+	}
+
+	var worst TestFailure
+	most := 0
+	for f, n := range st.failures {
+		// Ties go to the first by name so it's the same every time.
+		if n > most || n == most && (f.Package < worst.Package || f.Package == worst.Package && f.Test < worst.Test) {
+			worst, most = f, n
+		}
+	}
+	if most > 0 {
+		fmt.Fprintf(out, "  most failures  %s in %s, %s\n", worst.Test, worst.Package, plural(most, "time"))
+	}
+}
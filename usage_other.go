@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// maxRSS isn't available on this platform.
+func maxRSS(ps *os.ProcessState) int64 {
+	return 0
+}
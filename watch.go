@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+// watchTree watches root and every directory below it in the background, skipping hidden ones
+// and those more than maxDepth levels below root, when it's 0 or more.
+// Each directory is sent on added once it's watched, then the walk's result is sent on done.
+func watchTree(watcher *fsnotify.Watcher, root string, maxDepth int, added chan<- string, done chan<- walkResult) {
+	go func() {
+		goFiles, err := walkTree(root, maxDepth, func(path string) error {
+			err := watcher.Add(path)
+			if err != nil {
+				return err
+			}
+			added <- path
+			return nil
+		})
+		done <- walkResult{goFiles: goFiles, err: err}
+	}()
+}
+
+// walkTree calls dir for root and each directory below it that watchTree
+// watches, returning how many Go files are in them.
+func walkTree(root string, maxDepth int, dir func(path string) error) (int, error) {
+	var goFiles int
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			logger.Debug("can't walk", "path", path, "err", err)
+			return nil
+		}
+		if !d.IsDir() {
+			if strings.HasSuffix(d.Name(), ".go") {
+				goFiles++
+			}
+			return nil
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if maxDepth >= 0 && depthBelow(root, path) > maxDepth {
+			return filepath.SkipDir
+		}
+		return dir(path)
+	})
+	return goFiles, err
+}
+
+// walkResult is what watchTree found, goFiles counts the Go files in the directories it watched.
+type walkResult struct {
+	goFiles int
+	err     error
+}
+
+// watchRoots returns the directories of watch that are within dir, or dir itself.
+func watchRoots(dir string, watch []string) []string {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil
+	}
+	var roots []string
+	for _, w := range watch {
+		abs, err := filepath.Abs(w)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(absDir, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		roots = append(roots, w)
+	}
+	return roots
+}
+
+// inTestdata reports whether path is inside a testdata directory.
+func inTestdata(path string) bool {
+	for _, dir := range strings.Split(filepath.ToSlash(filepath.Dir(path)), "/") {
+		if dir == "testdata" {
+			return true
+		}
+	}
+	return false
+}
+
+// inTestOnly reports whether path is matched by one of the -test-only patterns,
+// which are relative to the module in dir.
+func inTestOnly(dir, path string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && matchEmbed(rel, patterns)
+}
+
+// depthBelow is how many directories deep path is below root, 0 for root itself.
+func depthBelow(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return len(strings.Split(rel, string(filepath.Separator)))
+}
+
+// treeDepth is how much deeper than dir a new directory tree may be watched for
+// -max-depth, negative for no limit. It returns false if dir is too deep itself.
+func treeDepth(maxDepth int, roots []string, dir string) (int, bool) {
+	if maxDepth <= 0 {
+		return -1, true
+	}
+	depth := -1
+	for _, root := range roots {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if d := depthBelow(root, dir); depth < 0 || d < depth {
+			depth = d
+		}
+	}
+	if depth < 0 || depth > maxDepth {
+		return 0, false
+	}
+	return maxDepth - depth, true
+}
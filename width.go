@@ -0,0 +1,257 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+)
+
+// columns is the width of the terminal, measured by watchWidth.
+var columns atomic.Int32
+
+// watchWidth measures the width of the terminal now and again whenever it's resized.
+func watchWidth() {
+	columns.Store(int32(terminalWidth()))
+	resized := make(chan os.Signal, 1)
+	notifyResize(resized)
+	go func() {
+		for range resized {
+			columns.Store(int32(terminalWidth()))
+		}
+	}()
+}
+
+// terminalColumns returns the last measured width of the terminal.
+func terminalColumns() int {
+	if cols := int(columns.Load()); cols > 0 {
+		return cols
+	}
+	return terminalWidth()
+}
+
+// wideRunes are the ranges of East Asian wide and fullwidth characters, and
+// emoji, which take up two columns.
+var wideRunes = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x1100, 0x115f, 1},
+		{0x231a, 0x231b, 1},
+		{0x2329, 0x232a, 1},
+		{0x23e9, 0x23ec, 1},
+		{0x2e80, 0x303e, 1},
+		{0x3041, 0x33ff, 1},
+		{0x3400, 0x4dbf, 1},
+		{0x4e00, 0x9fff, 1},
+		{0xa000, 0xa4cf, 1},
+		{0xa960, 0xa97f, 1},
+		{0xac00, 0xd7a3, 1},
+		{0xf900, 0xfaff, 1},
+		{0xfe10, 0xfe19, 1},
+		{0xfe30, 0xfe6f, 1},
+		{0xff00, 0xff60, 1},
+		{0xffe0, 0xffe6, 1},
+	},
+	R32: []unicode.Range32{
+		{0x1f300, 0x1f64f, 1},
+		{0x1f900, 0x1f9ff, 1},
+		{0x20000, 0x2fffd, 1},
+		{0x30000, 0x3fffd, 1},
+	},
+}
+
+// advance returns the column after printing r at column col.
+func advance(col int, r rune) int {
+	switch {
+	case r == '\t':
+		return (col/8 + 1) * 8
+	case r < ' ' || r == 0x7f || unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return col
+	case unicode.Is(wideRunes, r):
+		return col + 2
+	}
+	return col + 1
+}
+
+// escapeLen returns the length of the terminal escape sequence s starts with, 0 if it doesn't.
+func escapeLen(s string) int {
+	if len(s) < 2 || s[0] != '\033' {
+		return 0
+	}
+	if s[1] == ']' {
+		// An operating system command like a hyperlink, ended by BEL or ESC \.
+		for i := 2; i < len(s); i++ {
+			if s[i] == '\a' {
+				return i + 1
+			}
+			if s[i] == '\033' && i+1 < len(s) && s[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return len(s)
+	}
+	if s[1] != '[' {
+		return 2
+	}
+	for i := 2; i < len(s); i++ {
+		if s[i] >= 0x40 && s[i] <= 0x7e {
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// displayWidth returns how many columns s takes up on a terminal.
+func displayWidth(s string) int {
+	col := 0
+	for i := 0; i < len(s); {
+		if n := escapeLen(s[i:]); n > 0 {
+			i += n
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		col = advance(col, r)
+		i += size
+	}
+	return col
+}
+
+// truncateLine cuts line down to cols columns, ending it with "…" if anything was
+// cut. Escape sequences are never split, the colors and links are reset after a cut.
+func truncateLine(line string, cols int) string {
+	if displayWidth(line) <= cols {
+		return line
+	}
+	var b strings.Builder
+	col := 0
+	colored, linked := false, false
+	for i := 0; i < len(line); {
+		if n := escapeLen(line[i:]); n > 0 {
+			b.WriteString(line[i : i+n])
+			if line[i+1] == ']' {
+				linked = true
+			} else {
+				colored = true
+			}
+			i += n
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(line[i:])
+		next := advance(col, r)
+		if next > cols-1 {
+			break
+		}
+		b.WriteString(line[i : i+size])
+		col = next
+		i += size
+	}
+	b.WriteString("…")
+	if colored {
+		b.WriteString("\033[0m")
+	}
+	if linked {
+		// End any link that was cut short.
+		b.WriteString("\033]8;;\033\\")
+	}
+	return b.String()
+}
+
+// truncateLines cuts every line of text down to cols columns.
+func truncateLines(text string, cols int) string {
+	if cols < 2 {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = truncateLine(line, cols)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// wrapLine splits line into lines of at most cols columns, with tabs expanded
+// so they still line up when printed away from the left edge. The colors and
+// link of a line that's split are ended at the cut and carried on below it.
+func wrapLine(line string, cols int) []string {
+	var lines []string
+	var b strings.Builder
+	// color is the text's color at that point, link the link it's in.
+	color, link := "", ""
+	col := 0
+	cut := func() {
+		if color != "" {
+			b.WriteString("\033[0m")
+		}
+		if link != "" {
+			b.WriteString("\033]8;;\033\\")
+		}
+		lines = append(lines, b.String())
+		b.Reset()
+		b.WriteString(link + color)
+		col = 0
+	}
+	for i := 0; i < len(line); {
+		if n := escapeLen(line[i:]); n > 0 {
+			seq := line[i : i+n]
+			b.WriteString(seq)
+			switch {
+			case strings.HasPrefix(seq, "\033]8;;\033") || strings.HasPrefix(seq, "\033]8;;\a"):
+				link = ""
+			case strings.HasPrefix(seq, "\033]8;"):
+				link = seq
+			case seq == "\033[0m" || seq == "\033[m":
+				color = ""
+			case strings.HasSuffix(seq, "m"):
+				color += seq
+			}
+			i += n
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(line[i:])
+		i += size
+		next := advance(col, r)
+		if next > cols && col > 0 {
+			cut()
+			next = advance(col, r)
+		}
+		if r == '\t' {
+			b.WriteString(strings.Repeat(" ", next-col))
+		} else {
+			b.WriteRune(r)
+		}
+		col = next
+	}
+	return append(lines, b.String())
+}
+
+// sideBySide lays left and right out in two columns of half the width cols,
+// wrapping their lines to fit.
+func sideBySide(left, right string, cols int) string {
+	const gap = " │ "
+	width := (cols - len([]rune(gap))) / 2
+	split := func(text string) []string {
+		var lines []string
+		for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+			lines = append(lines, wrapLine(line, width)...)
+		}
+		return lines
+	}
+	l, r := split(left), split(right)
+
+	var b strings.Builder
+	for i := 0; i < len(l) || i < len(r); i++ {
+		var line string
+		if i < len(l) {
+			line = l[i]
+		}
+		pad := width - displayWidth(line)
+		if pad < 0 {
+			pad = 0
+		}
+		b.WriteString(line + strings.Repeat(" ", pad) + dim(gap))
+		if i < len(r) {
+			b.WriteString(r[i])
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,77 @@
+package main
+
+import "testing"
+
+func TestEscapeLen(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"", 0},
+		{"a\033[31m", 0},
+		{"\033", 0},
+		{"\033[31mred", 5},
+		{"\033[0m", 4},
+		{"\033[1;38;5;208mx", 13},
+		{"\033[31", 4},
+		{"\033(B", 2},
+		{"\033]8;;file:///a.go\033\\a.go", 19},
+		{"\033]8;;file:///a.go\aa.go", 18},
+		{"\033]8;;file:///a.go", 17},
+	}
+	for _, test := range tests {
+		if got := escapeLen(test.s); got != test.want {
+			t.Errorf("escapeLen(%q) = %d, want %d", test.s, got, test.want)
+		}
+	}
+}
+
+func TestTruncateLine(t *testing.T) {
+	tests := []struct {
+		line string
+		cols int
+		want string
+	}{
+		{"hello", 5, "hello"},
+		{"hello", 10, "hello"},
+		{"hello world", 5, "hell…"},
+		{"hello world", 1, "…"},
+		// Escapes take no columns and are never split.
+		{"\033[31mhello\033[0m", 5, "\033[31mhello\033[0m"},
+		{"\033[31mhello world\033[0m", 6, "\033[31mhello…\033[0m"},
+		{"ab\033[31mcdef", 3, "ab\033[31m…\033[0m"},
+		// A link cut short is ended.
+		{"\033]8;;file:///a.go\033\\main.go:12\033]8;;\033\\", 5, "\033]8;;file:///a.go\033\\main…\033]8;;\033\\"},
+		// Wide runes take two columns and aren't cut in half.
+		{"日本語", 6, "日本語"},
+		{"日本語です", 6, "日本…"},
+		{"a日本語", 4, "a日…"},
+		{"a日本語", 3, "a…"},
+		// Combining marks take none.
+		{"ééé", 3, "ééé"},
+	}
+	for _, test := range tests {
+		if got := truncateLine(test.line, test.cols); got != test.want {
+			t.Errorf("truncateLine(%q, %d) = %q, want %q", test.line, test.cols, got, test.want)
+		}
+	}
+}
+
+func TestDisplayWidth(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"\033[31mabc\033[0m", 3},
+		{"日本", 4},
+		{"\ta", 9},
+		{"é", 1},
+	}
+	for _, test := range tests {
+		if got := displayWidth(test.s); got != test.want {
+			t.Errorf("displayWidth(%q) = %d, want %d", test.s, got, test.want)
+		}
+	}
+}
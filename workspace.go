@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// goWork is the part of go work edit -json's output that's needed.
+type goWork struct {
+	Use []struct {
+		DiskPath string
+	}
+}
+
+// workspaceModules returns the directories of the modules the go.work file
+// used in dir lists, for -workspace. They're relative to dir when they're below it.
+func workspaceModules(dir string) ([]string, error) {
+	cmd := exec.Command(goCommand, "env", "GOWORK")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go env GOWORK: %v", err)
+	}
+	path := strings.TrimSpace(string(out))
+	if path == "" || path == "off" {
+		return nil, fmt.Errorf("-workspace: there's no go.work file in %s or above it", dir)
+	}
+
+	cmd = exec.Command(goCommand, "work", "edit", "-json", path)
+	cmd.Dir = dir
+	out, err = cmd.Output()
+	if err != nil {
+		if exit, ok := err.(*exec.ExitError); ok && len(exit.Stderr) > 0 {
+			return nil, fmt.Errorf("go work edit: %s", strings.TrimSpace(string(exit.Stderr)))
+		}
+		return nil, fmt.Errorf("go work edit: %v", err)
+	}
+	var work goWork
+	err = json.Unmarshal(out, &work)
+	if err != nil {
+		return nil, fmt.Errorf("go work edit: %v", err)
+	}
+	if len(work.Use) == 0 {
+		return nil, fmt.Errorf("-workspace: %s doesn't use any modules", path)
+	}
+
+	base, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, use := range work.Use {
+		module := use.DiskPath
+		if !filepath.IsAbs(module) {
+			module = filepath.Join(filepath.Dir(path), module)
+		}
+		if rel, err := filepath.Rel(base, module); err == nil && !strings.HasPrefix(rel, "..") {
+			module = rel
+		}
+		dirs = append(dirs, module)
+	}
+	return dirs, nil
+}